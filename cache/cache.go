@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cached responses are versioned by namespace rather than deleted one by one.
+// A cache key embeds the current version of every namespace its data came from,
+// and a write bumps those versions, so entries built before the write are never
+// read again and just expire. Versions are drawn from one counter, which keeps
+// them unique even after a version key expires and is recreated.
+const (
+	versionPrefix = "cache:version:"
+	versionSeq    = "cache:version:seq"
+
+	// versionTTL must outlive every cached entry: a version that expires reads as
+	// "0" again, which is only safe once entries from the last "0" are gone
+	versionTTL = 24 * time.Hour
+)
+
+// bumpScript assigns every namespace in KEYS[2..] a fresh version from the counter in KEYS[1]
+var bumpScript = redis.NewScript(`
+local version = redis.call('INCR', KEYS[1])
+for i = 2, #KEYS do
+	redis.call('SET', KEYS[i], version, 'PX', ARGV[1])
+end
+return version
+`)
+
+// UserNamespace covers everything cached about a user's set of files: listings,
+// search results and suggestions
+func UserNamespace(owner string) string {
+	return "user:" + owner
+}
+
+// FileNamespace covers everything cached about a single file
+func FileNamespace(fileID int) string {
+	return "file:" + strconv.Itoa(fileID)
+}
+
+// Key returns key qualified with the current versions of namespaces. Read and
+// write the cached entry under the returned key.
+func Key(ctx context.Context, rdb *redis.Client, key string, namespaces ...string) (string, error) {
+	if len(namespaces) == 0 {
+		return key, nil
+	}
+	keys := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		keys[i] = versionPrefix + ns
+	}
+	values, err := rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return "", err
+	}
+	versions := make([]string, len(values))
+	for i, value := range values {
+		versions[i] = "0"
+		if s, ok := value.(string); ok {
+			versions[i] = s
+		}
+	}
+	return key + "#v" + strings.Join(versions, "."), nil
+}
+
+// Invalidate makes every entry cached under namespaces unreachable. Call it
+// after the write that changed their data has committed.
+func Invalidate(ctx context.Context, rdb *redis.Client, namespaces ...string) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(namespaces)+1)
+	keys = append(keys, versionSeq)
+	seen := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		if !seen[ns] {
+			seen[ns] = true
+			keys = append(keys, versionPrefix+ns)
+		}
+	}
+	return bumpScript.Run(ctx, rdb, keys, versionTTL.Milliseconds()).Err()
+}
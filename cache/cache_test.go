@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func testRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	downUntil.Store(0)
+	return mr, rdb
+}
+
+func TestKeyChangesOnlyWithItsNamespaces(t *testing.T) {
+	_, rdb := testRedis(t)
+	ctx := context.Background()
+
+	plain, err := Key(ctx, rdb, "list")
+	if err != nil || plain != "list" {
+		t.Fatalf("Key without namespaces = %q, %v", plain, err)
+	}
+
+	key := func() string {
+		k, err := Key(ctx, rdb, "list", UserNamespace("a@example.com"), FileNamespace(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	before := key()
+	if again := key(); again != before {
+		t.Fatalf("Key is not stable: %q then %q", before, again)
+	}
+
+	if err := Invalidate(ctx, rdb, UserNamespace("b@example.com"), FileNamespace(2)); err != nil {
+		t.Fatal(err)
+	}
+	if k := key(); k != before {
+		t.Fatalf("unrelated invalidation changed %q to %q", before, k)
+	}
+
+	if err := Invalidate(ctx, rdb, FileNamespace(1)); err != nil {
+		t.Fatal(err)
+	}
+	after := key()
+	if after == before {
+		t.Fatalf("Key %q unchanged after invalidating one of its namespaces", after)
+	}
+	if err := Invalidate(ctx, rdb, UserNamespace("a@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if k := key(); k == after || k == before {
+		t.Fatalf("Key %q repeated an earlier version", k)
+	}
+}
+
+// TestVersionsNeverRepeat checks that a namespace whose version key expired
+// cannot come back to a version an older entry was cached under
+func TestVersionsNeverRepeat(t *testing.T) {
+	mr, rdb := testRedis(t)
+	ctx := context.Background()
+	ns := FileNamespace(7)
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		if err := Invalidate(ctx, rdb, ns); err != nil {
+			t.Fatal(err)
+		}
+		k, err := Key(ctx, rdb, "meta", ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[k] {
+			t.Fatalf("version %q reused", k)
+		}
+		seen[k] = true
+		mr.FastForward(versionTTL + time.Second)
+	}
+}
+
+func TestFetchCachesUntilInvalidated(t *testing.T) {
+	_, rdb := testRedis(t)
+	ctx := context.Background()
+	opts := Options{TTL: time.Minute, Namespaces: []string{UserNamespace("a@example.com")}}
+
+	var loads atomic.Int32
+	load := func(ctx context.Context) ([]byte, error) {
+		n := loads.Add(1)
+		return []byte{'0' + byte(n)}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := Fetch(ctx, rdb, "files", opts, load)
+		if err != nil || string(got) != "1" {
+			t.Fatalf("Fetch #%d = %q, %v; want the first load", i, got, err)
+		}
+	}
+
+	if err := Invalidate(ctx, rdb, UserNamespace("a@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Fetch(ctx, rdb, "files", opts, load)
+	if err != nil || string(got) != "2" {
+		t.Fatalf("Fetch after Invalidate = %q, %v; want a fresh load", got, err)
+	}
+	if n := loads.Load(); n != 2 {
+		t.Fatalf("loaded %d times, want 2", n)
+	}
+}
+
+func TestFetchRemembersMisses(t *testing.T) {
+	_, rdb := testRedis(t)
+	ctx := context.Background()
+	opts := Options{TTL: time.Minute, NegativeTTL: 30 * time.Second, Namespaces: []string{FileNamespace(9)}}
+
+	var loads atomic.Int32
+	missing := func(ctx context.Context) ([]byte, error) {
+		loads.Add(1)
+		return nil, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := Fetch(ctx, rdb, "meta:9", opts, missing); err != ErrNotFound {
+			t.Fatalf("Fetch = %v, want ErrNotFound", err)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("miss loaded %d times, want 1", n)
+	}
+
+	// A write that creates the file invalidates the remembered miss
+	if err := Invalidate(ctx, rdb, FileNamespace(9)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Fetch(ctx, rdb, "meta:9", opts, func(ctx context.Context) ([]byte, error) {
+		return []byte(`{"id":9}`), nil
+	})
+	if err != nil || string(got) != `{"id":9}` {
+		t.Fatalf("Fetch after Invalidate = %q, %v", got, err)
+	}
+}
+
+func TestFetchServesFromLoaderWhenRedisIsDown(t *testing.T) {
+	mr, rdb := testRedis(t)
+	mr.Close()
+	t.Cleanup(func() { downUntil.Store(0) })
+
+	got, err := Fetch(context.Background(), rdb, "files", Options{TTL: time.Minute},
+		func(ctx context.Context) ([]byte, error) { return []byte("fresh"), nil })
+	if err != nil || string(got) != "fresh" {
+		t.Fatalf("Fetch = %q, %v", got, err)
+	}
+	if available() {
+		t.Fatal("Redis not marked down after a failed call")
+	}
+}
+
+// TestFetchStopsWaitingWhenCallerLeaves checks that a caller whose context ends
+// returns at once while the shared load carries on for the others, and that
+// Redis stays in use
+func TestFetchStopsWaitingWhenCallerLeaves(t *testing.T) {
+	_, rdb := testRedis(t)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var startOnce sync.Once
+	var loadErr atomic.Value
+	load := func(ctx context.Context) ([]byte, error) {
+		startOnce.Do(func() { close(started) })
+		<-release
+		if err := ctx.Err(); err != nil {
+			loadErr.Store(err)
+		}
+		return []byte("slow"), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := Fetch(ctx, rdb, "slow", Options{TTL: time.Minute}, load)
+		first <- err
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-first:
+		if err != context.Canceled {
+			t.Fatalf("Fetch = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fetch kept waiting after its caller's context ended")
+	}
+
+	second := make(chan []byte, 1)
+	go func() {
+		got, _ := Fetch(context.Background(), rdb, "slow", Options{TTL: time.Minute}, load)
+		second <- got
+	}()
+	time.Sleep(50 * time.Millisecond) // let the second caller join the running load
+	close(release)
+	if got := <-second; string(got) != "slow" {
+		t.Fatalf("second caller got %q", got)
+	}
+	if err := loadErr.Load(); err != nil {
+		t.Fatalf("shared load saw %v after the first caller left", err)
+	}
+
+	// A caller that is gone before Fetch reaches Redis fails its Redis calls;
+	// that must not take Redis out of use for everyone else
+	gone, cancelGone := context.WithCancel(context.Background())
+	cancelGone()
+	if _, err := Fetch(gone, rdb, "other", Options{TTL: time.Minute}, load); err != context.Canceled {
+		t.Fatalf("Fetch with a cancelled context = %v, want context.Canceled", err)
+	}
+	if !available() {
+		t.Fatal("Redis marked down because a caller went away")
+	}
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned by a Loader when there is nothing to serve. Fetch
+// remembers the miss for Options.NegativeTTL and returns it to later callers.
+var ErrNotFound = errors.New("not found")
+
+// Loader produces the JSON document to cache, usually from Postgres
+type Loader func(ctx context.Context) ([]byte, error)
+
+// Options configures one cached document
+type Options struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration // how long ErrNotFound is remembered; 0 disables
+	Namespaces  []string      // versions the key depends on, see Key
+}
+
+const (
+	// opTimeout bounds each Redis call, so a slow Redis costs a request at most this much
+	opTimeout = 250 * time.Millisecond
+
+	// retryAfter is how long Fetch bypasses Redis after it fails
+	retryAfter = 5 * time.Second
+
+	// loadTimeout bounds a load shared by concurrent callers. It runs detached
+	// from any one caller's context, so it needs a deadline of its own.
+	loadTimeout = 30 * time.Second
+
+	// earlyRefreshBeta scales how eagerly entries are refreshed before they
+	// expire; 1 is the value the XFetch paper recommends
+	earlyRefreshBeta = 1.0
+)
+
+// entry is the stored form of a cached document. Delta is how long the loader
+// took, which sets how early the entry is refreshed.
+type entry struct {
+	Value   json.RawMessage `json:"v,omitempty"`
+	Missing bool            `json:"m,omitempty"`
+	Delta   int64           `json:"d"` // milliseconds
+	Expiry  int64           `json:"e"` // unix milliseconds
+}
+
+var (
+	group     singleflight.Group
+	downUntil atomic.Int64 // unix nanoseconds; Redis is skipped until then
+)
+
+// Fetch returns the document cached under key, calling load on a miss and
+// caching the result. Concurrent misses for the same key in this process share
+// one load. Shortly before an entry expires, one caller at random refreshes it
+// in the background while everyone keeps getting the cached copy, so a popular
+// key never expires under load. When Redis fails, Fetch serves straight from
+// load and leaves Redis alone for a few seconds. A caller whose ctx ends stops
+// waiting, but the shared load runs on, up to loadTimeout, for the others.
+func Fetch(ctx context.Context, rdb *redis.Client, key string, opts Options, load Loader) ([]byte, error) {
+	if !available() {
+		return loadShared(ctx, key, load)
+	}
+
+	versioned, err := withTimeout(ctx, func(ctx context.Context) (string, error) {
+		return Key(ctx, rdb, key, opts.Namespaces...)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err() // the caller is gone, not Redis
+		}
+		markDown(ctx, err)
+		return loadShared(ctx, key, load)
+	}
+	key = versioned
+
+	raw, err := withTimeout(ctx, func(ctx context.Context) ([]byte, error) {
+		return rdb.Get(ctx, key).Bytes()
+	})
+	if err != nil && err != redis.Nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err() // the caller is gone, not Redis
+		}
+		markDown(ctx, err)
+		return loadShared(ctx, key, load)
+	}
+	if err == nil {
+		var cached entry
+		if json.Unmarshal(raw, &cached) == nil {
+			if shouldRefresh(cached, time.Now()) {
+				refreshAsync(rdb, key, opts, load)
+			}
+			if cached.Missing {
+				return nil, ErrNotFound
+			}
+			return cached.Value, nil
+		}
+	}
+
+	return shared(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return loadAndStore(ctx, rdb, key, opts, load)
+	})
+}
+
+// loadShared loads without touching Redis, still coalescing concurrent callers
+func loadShared(ctx context.Context, key string, load Loader) ([]byte, error) {
+	return shared(ctx, "nocache:"+key, load)
+}
+
+// shared runs load once for all concurrent callers of key. The load must not
+// fail because the caller that started it went away, so it runs detached with
+// loadTimeout; each caller stops waiting when its own ctx is done.
+func shared(ctx context.Context, key string, load Loader) ([]byte, error) {
+	ch := group.DoChan(key, func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		return load(loadCtx)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// loadAndStore runs load and caches what it returns, including ErrNotFound
+func loadAndStore(ctx context.Context, rdb *redis.Client, key string, opts Options, load Loader) ([]byte, error) {
+	start := time.Now()
+	value, err := load(ctx)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	cached := entry{Value: value, Delta: time.Since(start).Milliseconds()}
+	ttl := opts.TTL
+	if err == ErrNotFound {
+		cached = entry{Missing: true, Delta: cached.Delta}
+		ttl = opts.NegativeTTL
+	}
+	if ttl > 0 && available() {
+		cached.Expiry = time.Now().Add(ttl).UnixMilli()
+		if data, jsonErr := json.Marshal(cached); jsonErr == nil {
+			_, setErr := withTimeout(ctx, func(ctx context.Context) (string, error) {
+				return rdb.Set(ctx, key, data, ttl).Result()
+			})
+			if setErr != nil {
+				markDown(ctx, setErr)
+			}
+		}
+	}
+	return value, err
+}
+
+// refreshAsync reloads key in the background unless a load is already running.
+// It runs detached from the request that triggered it.
+func refreshAsync(rdb *redis.Client, key string, opts Options, load Loader) {
+	group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		value, err := loadAndStore(ctx, rdb, key, opts, load)
+		if err != nil && err != ErrNotFound {
+			log.Printf(" Background cache refresh of %s failed: %v\n", key, err)
+		}
+		return value, err
+	})
+}
+
+// shouldRefresh implements XFetch: the closer an entry is to expiry, and the
+// slower it was to load, the more likely a read is to refresh it early
+func shouldRefresh(cached entry, now time.Time) bool {
+	if cached.Expiry == 0 {
+		return false
+	}
+	gap := float64(cached.Delta) * earlyRefreshBeta * -math.Log(1-rand.Float64())
+	return float64(now.UnixMilli())+gap >= float64(cached.Expiry)
+}
+
+func available() bool {
+	return time.Now().UnixNano() >= downUntil.Load()
+}
+
+// markDown makes Fetch bypass Redis for retryAfter, logging once per outage.
+// Calls that failed because ctx ended say nothing about Redis and are ignored,
+// or every client that hangs up would send the whole process to the database.
+func markDown(ctx context.Context, err error) {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	now := time.Now()
+	if downUntil.Swap(now.Add(retryAfter).UnixNano()) < now.UnixNano() {
+		log.Println(" Redis unavailable, serving from the database:", err)
+	}
+}
+
+func withTimeout[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	return fn(ctx)
+}
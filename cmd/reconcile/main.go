@@ -0,0 +1,58 @@
+// Command reconcile compares local uploads, the S3 bucket and the files table
+// and prints a JSON report of orphans, missing objects and size mismatches.
+//
+//	go run ./cmd/reconcile                      # report only
+//	go run ./cmd/reconcile -action quarantine   # move orphans older than the grace period aside
+//	go run ./cmd/reconcile -action delete -grace 72h
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/workers"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	action := flag.String("action", workers.ReconcileReport, "report, quarantine or delete")
+	grace := flag.Duration("grace", workers.DefaultGracePeriod(), "leave orphans younger than this alone (at least 1h to quarantine or delete)")
+	uploadDir := flag.String("uploads", "uploads", "local upload directory")
+	skipS3 := flag.Bool("skip-s3", false, "compare only local storage with the database")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("  Warning: No .env file found")
+	}
+
+	db, err := sql.Open("pgx", os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal(" Unable to connect to database:", err)
+	}
+	defer db.Close()
+
+	opts := workers.ReconcileOptions{Action: *action, GracePeriod: *grace, UploadDir: *uploadDir, SkipS3: *skipS3}
+	if *action != workers.ReconcileReport {
+		// Changing storage takes the cleanup lock, which lives in Redis
+		config.InitRedis()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := workers.RunReconcile(ctx, db, config.RDB, opts)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if err != nil {
+		log.Fatal(" Reconcile failed:", err)
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetEnvInt64 reads an integer environment variable, falling back to def when unset or invalid
+func GetEnvInt64(name string, def int64) int64 {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d\n", name, raw, def)
+		return def
+	}
+	return value
+}
+
+// GetEnvFloat reads a decimal environment variable such as "0.3"
+func GetEnvFloat(name string, def float64) float64 {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %g\n", name, raw, def)
+		return def
+	}
+	return value
+}
+
+// GetEnvDuration reads a duration environment variable such as "30s" or "1h"
+func GetEnvDuration(name string, def time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %s\n", name, raw, def)
+		return def
+	}
+	return value
+}
+
+// GetEnvBool reads a boolean environment variable such as "true" or "0"
+func GetEnvBool(name string, def bool) bool {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %t\n", name, raw, def)
+		return def
+	}
+	return value
+}
+
+// GetEnvList reads a comma separated environment variable, dropping empty entries
+func GetEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
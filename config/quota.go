@@ -0,0 +1,11 @@
+package config
+
+// DefaultUserQuota is the storage quota in bytes for users without an explicit quota_bytes
+func DefaultUserQuota() int64 {
+	return GetEnvInt64("USER_QUOTA_BYTES", 1<<30) // 1 GiB
+}
+
+// DefaultTeamQuota is the storage quota in bytes for teams without an explicit quota_bytes
+func DefaultTeamQuota() int64 {
+	return GetEnvInt64("TEAM_QUOTA_BYTES", 10<<30) // 10 GiB
+}
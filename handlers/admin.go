@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/lock"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/SOMAK939/file-sharing-platform/workers"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+// GetCleanupLock reports which instance holds the cleanup worker lock, when it was
+// acquired and last renewed, and the latest fencing token
+func GetCleanupLock(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+
+		state, err := lock.Inspect(r.Context(), RDB, workers.CleanupLockName)
+		if err != nil {
+			log.Println(" Lock lookup error:", err)
+			http.Error(w, "Failed to load lock state", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	}
+}
+
+// ListCleanupRuns returns the statistics of recent cleanup passes, newest first.
+// Query: limit (default 20, max 200).
+func ListCleanupRuns(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		limit := 20
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 200)
+		}
+
+		runs, err := workers.ListCleanupRuns(r.Context(), db, limit)
+		if err != nil {
+			log.Println(" Cleanup run lookup error:", err)
+			http.Error(w, "Failed to load cleanup runs", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"runs": runs})
+	}
+}
+
+// ListStuckDeletions returns files cleanup gave up deleting after
+// CLEANUP_MAX_ATTEMPTS failures. Query: limit (default 100, max 1000).
+func ListStuckDeletions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		limit := 100
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 1000)
+		}
+
+		stuck, err := workers.ListStuckDeletions(r.Context(), db, limit)
+		if err != nil {
+			log.Println(" Stuck deletion lookup error:", err)
+			http.Error(w, "Failed to load stuck deletions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": stuck})
+	}
+}
+
+// RetryStuckDeletion lets cleanup try a stuck file again on its next pass
+func RetryStuckDeletion(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		fileID, err := strconv.Atoi(mux.Vars(r)["file_id"])
+		if err != nil {
+			http.Error(w, "Invalid file id", http.StatusBadRequest)
+			return
+		}
+
+		reset, err := workers.RetryStuckDeletion(r.Context(), db, fileID)
+		if err != nil {
+			log.Println(" Stuck deletion retry error:", err)
+			http.Error(w, "Failed to retry deletion", http.StatusInternalServerError)
+			return
+		}
+		if !reset {
+			http.Error(w, "File is not a stuck deletion", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TriggerCleanup runs a cleanup pass now and returns its statistics. With
+// ?dry_run=true it only reports what would be deleted. It answers 409 while
+// another instance holds the cleanup lock.
+func TriggerCleanup(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+
+		run, err := workers.RunCleanup(r.Context(), db, RDB, dryRun)
+		if err == workers.ErrCleanupRunning {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil && run == nil {
+			log.Println(" Cleanup trigger error:", err)
+			http.Error(w, "Failed to run cleanup", http.StatusInternalServerError)
+			return
+		}
+
+		// A run that stopped part way still reports what it did, with its error
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(run)
+	}
+}
+
+// Reconcile compares local storage, S3 and the database and reports orphans,
+// missing objects and size mismatches. GET only reports; POST with
+// ?action=quarantine or ?action=delete also handles orphans older than
+// ?grace= (default RECONCILE_GRACE_PERIOD, at least workers.MinGracePeriod).
+// ?skip_s3=true compares local storage only.
+func Reconcile(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		q := r.URL.Query()
+		opts := workers.ReconcileOptions{
+			Action:      workers.ReconcileReport,
+			GracePeriod: workers.DefaultGracePeriod(),
+			SkipS3:      q.Get("skip_s3") == "true",
+		}
+		if r.Method == http.MethodPost && q.Get("action") != "" {
+			opts.Action = q.Get("action")
+		}
+		if raw := q.Get("grace"); raw != "" {
+			grace, err := time.ParseDuration(raw)
+			if err != nil || grace < 0 {
+				http.Error(w, "Invalid grace period", http.StatusBadRequest)
+				return
+			}
+			opts.GracePeriod = grace
+		}
+		if opts.Action != workers.ReconcileReport && opts.Action != workers.ReconcileQuarantine && opts.Action != workers.ReconcileDelete {
+			http.Error(w, "Invalid action: expected report, quarantine or delete", http.StatusBadRequest)
+			return
+		}
+		if opts.Action != workers.ReconcileReport && opts.GracePeriod < workers.MinGracePeriod {
+			http.Error(w, fmt.Sprintf("Grace period must be at least %s to %s orphans", workers.MinGracePeriod, opts.Action), http.StatusBadRequest)
+			return
+		}
+
+		report, err := workers.RunReconcile(r.Context(), db, RDB, opts)
+		if err == workers.ErrCleanupRunning {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil && report == nil {
+			log.Println(" Reconcile error:", err)
+			http.Error(w, "Failed to reconcile storage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// ListIntegrityIssues lists files the scrub worker found corrupt or missing.
+// Query: status=corrupt|missing, limit (default 50, max 500).
+func ListIntegrityIssues(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		q := r.URL.Query()
+		status := q.Get("status")
+		if status != "" && status != workers.IntegrityCorrupt && status != workers.IntegrityMissing {
+			http.Error(w, "Invalid status: expected corrupt or missing", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 500)
+		}
+
+		issues, err := workers.ListIntegrityIssues(r.Context(), db, status, limit)
+		if err != nil {
+			log.Println(" Integrity lookup error:", err)
+			http.Error(w, "Failed to load integrity issues", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": issues})
+	}
+}
+
+// ListQuarantinedFiles lists files held back by malware scanning: quarantined
+// ones with the signature found, and ones still waiting for a verdict with the
+// last scan error. Query: status=quarantined|pending_scan, limit (default 50, max 500).
+func ListQuarantinedFiles(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		q := r.URL.Query()
+		status := q.Get("status")
+		if status != "" && status != statusQuarantined && status != statusPendingScan {
+			http.Error(w, "Invalid status: expected quarantined or pending_scan", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 500)
+		}
+
+		files, err := listHeldFiles(r.Context(), db, status, limit)
+		if err != nil {
+			log.Println(" Quarantine lookup error:", err)
+			http.Error(w, "Failed to load quarantined files", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+	}
+}
+
+// TriggerScrub verifies the next batch of files now and returns the counts.
+// It answers 409 while another instance is scrubbing.
+func TriggerScrub(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+
+		result, err := workers.RunScrub(r.Context(), db, RDB)
+		if err == workers.ErrScrubRunning {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println(" Scrub trigger error:", err)
+			http.Error(w, "Failed to run scrub", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// ListUploadPolicies returns the stored upload policies and the policy from the
+// environment that applies when none of them matches
+func ListUploadPolicies(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+
+		policies, err := utils.ListUploadPolicies(db)
+		if err != nil {
+			log.Println(" Upload policy lookup error:", err)
+			http.Error(w, "Failed to load upload policies", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"policies": policies, "fallback": utils.EnvUploadPolicy()})
+	}
+}
+
+// SaveUploadPolicy creates or replaces the upload policy for the subject in the
+// route: "default", "role:<role>" or "team:<id>"
+func SaveUploadPolicy(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+		var policy utils.UploadPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		policy.Subject = mux.Vars(r)["subject"]
+
+		if err := utils.SaveUploadPolicy(db, &policy); err != nil {
+			var validationErr *utils.UploadPolicyValidationError
+			if errors.As(err, &validationErr) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Println(" Upload policy save error:", err)
+			http.Error(w, "Failed to save upload policy", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy)
+	}
+}
+
+// DeleteUploadPolicy removes the upload policy for the subject in the route
+func DeleteUploadPolicy(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireAdmin(db, w, r); !ok {
+			return
+		}
+
+		deleted, err := utils.DeleteUploadPolicy(db, mux.Vars(r)["subject"])
+		if err != nil {
+			log.Println(" Upload policy delete error:", err)
+			http.Error(w, "Failed to delete upload policy", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Upload policy not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/cache"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+// FileAnnotations are the user-editable tags, description and metadata of a file
+type FileAnnotations struct {
+	ID          int               `json:"id"`
+	Tags        []string          `json:"tags"`
+	Description string            `json:"description"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// AnnotationPatch is the body of PATCH /files/{file_id}. Omitted fields are left
+// alone; a metadata value of null removes that key.
+type AnnotationPatch struct {
+	Description *string            `json:"description"`
+	Tags        *[]string          `json:"tags"` // replaces every tag
+	Metadata    map[string]*string `json:"metadata"`
+}
+
+// TagsRequest is the body of POST /files/{file_id}/tags
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagCount is one entry of GET /tags
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// annotationLimitError is returned when an edit would exceed a per-file limit
+type annotationLimitError struct{ msg string }
+
+func (e annotationLimitError) Error() string { return e.msg }
+
+// UpdateFileAnnotations patches the description, tags and custom metadata of
+// one of the caller's files and returns the result
+func UpdateFileAnnotations(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch AnnotationPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		var tags []string
+		if patch.Tags != nil {
+			var err error
+			if tags, err = utils.NormalizeTags(*patch.Tags); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if patch.Description != nil && len([]rune(*patch.Description)) > utils.MaxDescriptionLength {
+			http.Error(w, fmt.Sprintf("description is longer than %d characters", utils.MaxDescriptionLength), http.StatusBadRequest)
+			return
+		}
+		for key, value := range patch.Metadata {
+			if err := utils.ValidateMetadataKey(key); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if value != nil {
+				if err := utils.ValidateMetadataValue(key, *value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		editAnnotations(db, RDB, w, r, func(a *FileAnnotations) error {
+			if patch.Description != nil {
+				a.Description = *patch.Description
+			}
+			if patch.Tags != nil {
+				if len(tags) > utils.MaxTags {
+					return annotationLimitError{fmt.Sprintf("a file can have at most %d tags", utils.MaxTags)}
+				}
+				a.Tags = tags
+			}
+			for key, value := range patch.Metadata {
+				if value == nil {
+					delete(a.Metadata, key)
+				} else {
+					a.Metadata[key] = *value
+				}
+			}
+			if len(a.Metadata) > utils.MaxMetadataKeys {
+				return annotationLimitError{fmt.Sprintf("a file can have at most %d metadata keys", utils.MaxMetadataKeys)}
+			}
+			return nil
+		})
+	}
+}
+
+// AddFileTags adds tags to one of the caller's files, keeping those it has
+func AddFileTags(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TagsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		tags, err := utils.NormalizeTags(req.Tags)
+		if err != nil || len(tags) == 0 {
+			if err == nil {
+				err = fmt.Errorf("tags is required")
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		editAnnotations(db, RDB, w, r, func(a *FileAnnotations) error {
+			merged, _ := utils.NormalizeTags(append(a.Tags, tags...))
+			if len(merged) > utils.MaxTags {
+				return annotationLimitError{fmt.Sprintf("a file can have at most %d tags", utils.MaxTags)}
+			}
+			a.Tags = merged
+			return nil
+		})
+	}
+}
+
+// RemoveFileTag removes one tag from one of the caller's files. Removing a tag
+// the file does not have is not an error.
+func RemoveFileTag(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag, err := utils.NormalizeTag(mux.Vars(r)["tag"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		editAnnotations(db, RDB, w, r, func(a *FileAnnotations) error {
+			kept := a.Tags[:0]
+			for _, t := range a.Tags {
+				if t != tag {
+					kept = append(kept, t)
+				}
+			}
+			a.Tags = kept
+			return nil
+		})
+	}
+}
+
+// editAnnotations applies edit to the annotations of the caller's file named in
+// the route, saves them and writes them back as the response
+func editAnnotations(db *sql.DB, RDB *redis.Client, w http.ResponseWriter, r *http.Request, edit func(*FileAnnotations) error) {
+	userID, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["file_id"])
+	if err != nil {
+		http.Error(w, "Invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	annotations, err := updateAnnotations(r.Context(), db, userID, fileID, edit)
+	var limitErr annotationLimitError
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	case errors.As(err, &limitErr):
+		http.Error(w, limitErr.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Println(" Annotation update error:", err)
+		http.Error(w, "Failed to update file", http.StatusInternalServerError)
+		return
+	}
+	invalidateCache(RDB, userID, fileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(annotations)
+}
+
+// updateAnnotations reads the annotations of owner's file under a row lock,
+// applies edit and writes them back in the same transaction
+func updateAnnotations(ctx context.Context, db *sql.DB, owner string, fileID int, edit func(*FileAnnotations) error) (*FileAnnotations, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	a := &FileAnnotations{ID: fileID}
+	var tags, metadata []byte
+	err = tx.QueryRowContext(ctx, `SELECT to_json(tags), description, metadata FROM files
+		WHERE id = $1 AND owner_id = $2 AND status <> 'deleting' FOR UPDATE`, fileID, owner).
+		Scan(&tags, &a.Description, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tags, &a.Tags); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metadata, &a.Metadata); err != nil {
+		return nil, err
+	}
+
+	if err := edit(a); err != nil {
+		return nil, err
+	}
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	metadata, err = json.Marshal(a.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE files SET tags = $1, description = $2, metadata = $3::jsonb WHERE id = $4",
+		a.Tags, a.Description, string(metadata), fileID)
+	if err != nil {
+		return nil, err
+	}
+	return a, tx.Commit()
+}
+
+// ListTags returns every tag on the caller's files with how many files carry it,
+// most used first
+func ListTags(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		data, err := cache.Fetch(r.Context(), RDB, "tags:"+userID, cache.Options{
+			TTL:        5 * time.Minute,
+			Namespaces: []string{cache.UserNamespace(userID)},
+		}, func(ctx context.Context) ([]byte, error) {
+			rows, err := db.QueryContext(ctx, `SELECT tag, COUNT(*) FROM files, unnest(tags) AS tag
+				WHERE owner_id = $1 AND status <> 'deleting'
+				GROUP BY tag ORDER BY COUNT(*) DESC, tag`, userID)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			counts := []TagCount{}
+			for rows.Next() {
+				var c TagCount
+				if err := rows.Scan(&c.Tag, &c.Count); err != nil {
+					return nil, err
+				}
+				counts = append(counts, c)
+			}
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+			return json.Marshal(counts)
+		})
+		if err != nil {
+			log.Println(" Tag listing error:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
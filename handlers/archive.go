@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+)
+
+// ArchiveRequest selects the files to bundle into a single download
+type ArchiveRequest struct {
+	FileIDs []int  `json:"file_ids"`
+	Folder  string `json:"folder"`
+	Format  string `json:"format"` // "zip" (default) or "tar.gz"
+	Name    string `json:"name"`
+}
+
+type archiveEntry struct {
+	ID       int
+	Filename string
+	Filepath string
+	URL      string
+	Folder   string
+}
+
+// storedExtensions are already compressed, so deflating them only costs CPU
+var storedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".7z": true, ".rar": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+	".mp3": true, ".mp4": true, ".mov": true, ".mkv": true,
+	".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// DownloadArchive streams the requested files, or every file in a folder, as a ZIP
+// or tar.gz built on the fly. Nothing is staged on disk; files the caller does not
+// own are rejected before the response starts, and files that cannot be read
+// once it has are listed in MISSING-FILES.txt inside the archive.
+func DownloadArchive(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		req, err := parseArchiveRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := loadArchiveEntries(db, userID, req)
+		if err != nil {
+			log.Println(" Archive query error:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		// Every explicitly requested id must belong to the caller
+		found := make(map[int]bool, len(entries))
+		for _, e := range entries {
+			found[e.ID] = true
+		}
+		var missing []string
+		for _, id := range req.FileIDs {
+			if !found[id] {
+				missing = append(missing, strconv.Itoa(id))
+			}
+		}
+		if len(missing) > 0 {
+			http.Error(w, "Files not found: "+strings.Join(missing, ", "), http.StatusNotFound)
+			return
+		}
+		if len(entries) == 0 {
+			http.Error(w, "No files to archive", http.StatusNotFound)
+			return
+		}
+		if maxFiles := config.GetEnvInt64("ARCHIVE_MAX_FILES", 1000); int64(len(entries)) > maxFiles {
+			http.Error(w, fmt.Sprintf("Archive limited to %d files", maxFiles), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		names := archiveNames(entries, req.Folder)
+		if req.Format == "tar.gz" {
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", req.Name+".tar.gz"))
+			writeTarGz(r, w, entries, names)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", req.Name+".zip"))
+		writeZip(r, w, entries, names)
+	}
+}
+
+// parseArchiveRequest reads the selection from a JSON body (POST) or the query string (GET)
+func parseArchiveRequest(r *http.Request) (ArchiveRequest, error) {
+	var req ArchiveRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("Invalid request payload")
+		}
+	} else {
+		q := r.URL.Query()
+		for _, raw := range strings.Split(q.Get("ids"), ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			id, err := strconv.Atoi(raw)
+			if err != nil {
+				return req, fmt.Errorf("Invalid file id %q", raw)
+			}
+			req.FileIDs = append(req.FileIDs, id)
+		}
+		req.Folder = q.Get("folder")
+		req.Format = q.Get("format")
+		req.Name = q.Get("name")
+	}
+
+	req.Folder = utils.NormalizeFolder(req.Folder)
+	if len(req.FileIDs) == 0 && req.Folder == "" {
+		return req, fmt.Errorf("file_ids or folder is required")
+	}
+	switch req.Format {
+	case "", "zip":
+		req.Format = "zip"
+	case "tar.gz", "tgz":
+		req.Format = "tar.gz"
+	default:
+		return req, fmt.Errorf("Unsupported archive format %q", req.Format)
+	}
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		req.Name = "files"
+		if req.Folder != "" {
+			req.Name = path.Base(req.Folder)
+		}
+	}
+	return req, nil
+}
+
+func loadArchiveEntries(db *sql.DB, userID string, req ArchiveRequest) ([]archiveEntry, error) {
+	ids := req.FileIDs
+	if ids == nil {
+		ids = []int{}
+	}
+	rows, err := db.Query(`SELECT id, filename, filepath, COALESCE(file_url, ''), COALESCE(folder, '')
+		FROM files
+		WHERE owner_id = $1 AND status = 'active'
+		  AND (id = ANY($2) OR ($3 <> '' AND (folder = $3 OR starts_with(folder, $3 || '/'))))
+		ORDER BY folder, id`, userID, ids, req.Folder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []archiveEntry
+	for rows.Next() {
+		var e archiveEntry
+		if err := rows.Scan(&e.ID, &e.Filename, &e.Filepath, &e.URL, &e.Folder); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// missingFilesEntry lists, at the end of an archive, the files that could not
+// be read; by then the response has started and can no longer fail
+const missingFilesEntry = "MISSING-FILES.txt"
+
+// archiveNames assigns each entry a unique path inside the archive. Files keep their
+// folder relative to the requested one, and clashes become "name (1).ext", "name (2).ext".
+func archiveNames(entries []archiveEntry, baseFolder string) []string {
+	used := map[string]bool{strings.ToLower(missingFilesEntry): true}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		dir := utils.NormalizeFolder(e.Folder)
+		if baseFolder != "" && (dir == baseFolder || strings.HasPrefix(dir, baseFolder+"/")) {
+			dir = strings.TrimPrefix(strings.TrimPrefix(dir, baseFolder), "/")
+		}
+		name := path.Join(dir, archiveBaseName(e))
+
+		candidate := name
+		ext := path.Ext(name)
+		stem := strings.TrimSuffix(name, ext)
+		for n := 1; used[strings.ToLower(candidate)]; n++ {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		}
+		used[strings.ToLower(candidate)] = true
+		names[i] = candidate
+	}
+	return names
+}
+
+// archiveBaseName is the last element of an entry's display name, so no name
+// can climb out of the directory an archive is extracted to. Names with nothing
+// usable left fall back to the file id.
+func archiveBaseName(e archiveEntry) string {
+	name := path.Base(strings.ReplaceAll(utils.DisplayName(e.Filename), "\\", "/"))
+	if strings.TrimSpace(name) == "" || name == "." || name == ".." || name == "/" {
+		return fmt.Sprintf("file-%d", e.ID)
+	}
+	return name
+}
+
+// missingFilesNote is the content of missingFilesEntry
+func missingFilesNote(missing []string) []byte {
+	return []byte("These files could not be read and are not in the archive:\n" + strings.Join(missing, "\n") + "\n")
+}
+
+// writeZip streams a ZIP archive. archive/zip switches to ZIP64 records on its own
+// once an entry or the archive passes the 4 GiB / 65535 entry limits.
+func writeZip(r *http.Request, w http.ResponseWriter, entries []archiveEntry, names []string) {
+	zw := zip.NewWriter(w)
+	var missing []string
+	for i, e := range entries {
+		obj, err := utils.OpenStoredFile(r.Context(), e.Filepath, e.URL)
+		if err != nil {
+			log.Printf(" Skipping file %d in archive: %v\n", e.ID, err)
+			missing = append(missing, fmt.Sprintf("%s (file %d)", names[i], e.ID))
+			continue
+		}
+
+		header := &zip.FileHeader{Name: names[i], Method: zip.Deflate, Modified: obj.ModTime}
+		if storedExtensions[strings.ToLower(path.Ext(names[i]))] {
+			header.Method = zip.Store
+		}
+		dst, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.Copy(dst, obj)
+		}
+		obj.Close()
+		if err != nil {
+			// The response is already streaming, so the client sees a truncated archive
+			log.Printf(" Archive stream aborted at file %d: %v\n", e.ID, err)
+			return
+		}
+	}
+	if len(missing) > 0 {
+		dst, err := zw.Create(missingFilesEntry)
+		if err == nil {
+			_, err = dst.Write(missingFilesNote(missing))
+		}
+		if err != nil {
+			log.Println(" Failed to list missing files in archive:", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Println(" Failed to finish archive:", err)
+	}
+}
+
+// writeTarGz streams a gzip compressed tar archive
+func writeTarGz(r *http.Request, w http.ResponseWriter, entries []archiveEntry, names []string) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var missing []string
+	for i, e := range entries {
+		obj, err := utils.OpenStoredFile(r.Context(), e.Filepath, e.URL)
+		if err != nil {
+			log.Printf(" Skipping file %d in archive: %v\n", e.ID, err)
+			missing = append(missing, fmt.Sprintf("%s (file %d)", names[i], e.ID))
+			continue
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:    names[i],
+			Mode:    0644,
+			Size:    obj.Size,
+			ModTime: obj.ModTime,
+			Format:  tar.FormatPAX,
+		})
+		if err == nil {
+			_, err = io.CopyN(tw, obj, obj.Size)
+		}
+		obj.Close()
+		if err != nil {
+			log.Printf(" Archive stream aborted at file %d: %v\n", e.ID, err)
+			return
+		}
+	}
+	if len(missing) > 0 {
+		note := missingFilesNote(missing)
+		err := tw.WriteHeader(&tar.Header{Name: missingFilesEntry, Mode: 0644, Size: int64(len(note)), ModTime: time.Now(), Format: tar.FormatPAX})
+		if err == nil {
+			_, err = tw.Write(note)
+		}
+		if err != nil {
+			log.Println(" Failed to list missing files in archive:", err)
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		log.Println(" Failed to finish archive:", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		log.Println(" Failed to finish archive:", err)
+	}
+}
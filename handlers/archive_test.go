@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		base    string
+		want    []string
+	}{
+		{
+			name:    "folders relative to the requested one",
+			entries: []archiveEntry{{ID: 1, Filename: "1700000000_a.txt", Folder: "docs/2024"}, {ID: 2, Filename: "b.txt", Folder: "docs"}},
+			base:    "docs",
+			want:    []string{"2024/a.txt", "b.txt"},
+		},
+		{
+			name:    "clashes are numbered",
+			entries: []archiveEntry{{ID: 1, Filename: "a.txt"}, {ID: 2, Filename: "A.txt"}, {ID: 3, Filename: "a.txt"}},
+			want:    []string{"a.txt", "A (1).txt", "a (2).txt"},
+		},
+		{
+			name: "names cannot leave the archive",
+			entries: []archiveEntry{
+				{ID: 1, Filename: "../../etc/passwd"},
+				{ID: 2, Filename: `..\..\boot.ini`},
+				{ID: 3, Filename: "/abs/path.txt"},
+				{ID: 4, Filename: "x.txt", Folder: "../up"},
+			},
+			want: []string{"passwd", "boot.ini", "path.txt", "up/x.txt"},
+		},
+		{
+			name:    "nothing usable falls back to the id",
+			entries: []archiveEntry{{ID: 7, Filename: ".."}, {ID: 8, Filename: "/"}, {ID: 9, Filename: "  "}},
+			want:    []string{"file-7", "file-8", "file-9"},
+		},
+		{
+			name:    "the missing files list keeps its name",
+			entries: []archiveEntry{{ID: 1, Filename: missingFilesEntry}},
+			want:    []string{"MISSING-FILES (1).txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := archiveNames(tt.entries, tt.base)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("archiveNames = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestZipListsUnreadableFiles checks that a file that cannot be opened once the
+// archive is streaming is named in MISSING-FILES.txt rather than dropped silently
+func TestZipListsUnreadableFiles(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(present, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entries := []archiveEntry{
+		{ID: 1, Filename: "a.txt", Filepath: present},
+		{ID: 2, Filename: "gone.txt", Filepath: filepath.Join(dir, "gone.txt")},
+	}
+	rec := httptest.NewRecorder()
+	writeZip(httptest.NewRequest(http.MethodGet, "/files/archive", nil), rec, entries, archiveNames(entries, ""))
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+	if contents["a.txt"] != "hello" {
+		t.Fatalf("a.txt = %q", contents["a.txt"])
+	}
+	if _, ok := contents["gone.txt"]; ok {
+		t.Fatal("unreadable file has an entry")
+	}
+	if note := contents[missingFilesEntry]; !strings.Contains(note, "gone.txt (file 2)") {
+		t.Fatalf("%s = %q", missingFilesEntry, note)
+	}
+}
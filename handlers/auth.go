@@ -6,8 +6,11 @@ import (
      "fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	appConfig "github.com/SOMAK939/file-sharing-platform/config"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -106,3 +109,64 @@ func LoginUser(db *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
 	}
 }
+
+// authenticateRequest validates the Bearer token on r and returns the caller's user ID.
+// It writes a 401 response and returns false when the token is missing or invalid.
+func authenticateRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, " Unauthorized: Missing token", http.StatusUnauthorized)
+		return "", false
+	}
+
+	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+	userID, err := appConfig.ValidateJWT(tokenStr)
+	if err != nil {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// optionalUser returns the caller's user ID if the request carries a valid token, or ""
+func optionalUser(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return ""
+	}
+	userID, err := appConfig.ValidateJWT(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// requireAdmin authenticates r and checks that the caller has the admin role.
+// It writes a 401 or 403 response and returns false otherwise.
+func requireAdmin(db *sql.DB, w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := authenticateRequest(w, r)
+	if !ok {
+		return "", false
+	}
+	admin, err := isAdmin(db, userID)
+	if err != nil {
+		fmt.Println(" Role lookup error:", err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return "", false
+	}
+	if !admin {
+		http.Error(w, "Forbidden: admin only", http.StatusForbidden)
+		return "", false
+	}
+	return userID, true
+}
+
+// isAdmin reports whether userID has the admin role
+func isAdmin(db *sql.DB, userID string) (bool, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE email = $1", userID).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	return role == "admin", nil
+}
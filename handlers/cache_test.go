@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SOMAK939/file-sharing-platform/cache"
+	"github.com/SOMAK939/file-sharing-platform/queue"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+// The tests below cover each write that must invalidate cached responses: the
+// write runs between two reads through the cache, and the second read has to
+// reach the database again and show the change. sqlmock fails any query that
+// is not expected, so a read served from a stale cache entry fails the test.
+
+const cacheTestOwner = "owner@example.com"
+
+// passThrough hands every argument to sqlmock unchanged; pgx accepts slices
+// that database/sql's default converter rejects
+type passThrough struct{}
+
+func (passThrough) ConvertValue(v any) (driver.Value, error) { return v, nil }
+
+type cacheTestEnv struct {
+	t    *testing.T
+	db   *sql.DB
+	mock sqlmock.Sqlmock
+	rdb  *redis.Client
+}
+
+func setupCacheTest(t *testing.T) *cacheTestEnv {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passThrough{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return &cacheTestEnv{t: t, db: db, mock: mock, rdb: rdb}
+}
+
+func (env *cacheTestEnv) done() {
+	env.t.Helper()
+	if err := env.mock.ExpectationsWereMet(); err != nil {
+		env.t.Fatal(err)
+	}
+}
+
+func testToken(t *testing.T, email string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"email": email}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// call runs handler on a request for target with the given route variables
+func call(t *testing.T, handler http.HandlerFunc, method, target string, vars map[string]string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, body)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken(t, cacheTestOwner))
+	req = mux.SetURLVars(req, vars)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func quoted(sql string) string { return regexp.QuoteMeta(sql) }
+
+var metadataQuery = quoted("status, to_json(tags), description, metadata")
+
+func metadataRows(id int, filename, tags string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "filename", "filepath", "file_url", "status", "tags", "description", "metadata"}).
+		AddRow(id, filename, "uploads/"+filename, "https://bucket.example/"+filename, "active", []byte(tags), "", []byte("{}"))
+}
+
+func readMetadata(t *testing.T, env *cacheTestEnv, id string) (int, FileMetadata) {
+	t.Helper()
+	rec := call(t, GetFileMetadata(env.db, env.rdb), http.MethodGet, "/files/"+id+"/metadata", map[string]string{"file_id": id}, nil, nil)
+	var file FileMetadata
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, file
+}
+
+var listingQuery = quoted("page AS (")
+
+var listingColumns = []string{"id", "filename", "file_url", "size", "mime_type", "folder", "tags", "description",
+	"metadata", "owner_id", "shared", "uploaded_at", "status", "rank", "sort_key", "snippet"}
+
+func listingRow(rows *sqlmock.Rows, id int, filename string, shared bool) *sqlmock.Rows {
+	return rows.AddRow(id, filename, "https://bucket.example/"+filename, 11, "text/plain", "", []byte("[]"), "",
+		[]byte("{}"), cacheTestOwner, shared, time.Now(), "active", 0.0, []byte(`"2024-01-01T00:00:00"`), "")
+}
+
+func readListing(t *testing.T, env *cacheTestEnv) []FileSummary {
+	t.Helper()
+	rec := call(t, GetUserFiles(env.db, env.rdb), http.MethodGet, "/user/files", nil, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing: %d %s", rec.Code, rec.Body)
+	}
+	var resp FileListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Files
+}
+
+func TestRenameInvalidatesMetadata(t *testing.T) {
+	env := setupCacheTest(t)
+	db, mock := env.db, env.mock
+
+	mock.ExpectQuery(metadataQuery).WithArgs(5, cacheTestOwner).WillReturnRows(metadataRows(5, "old.txt", "[]"))
+	for i := 0; i < 2; i++ { // the second read is served from the cache
+		if code, file := readMetadata(t, env, "5"); code != http.StatusOK || file.Filename != "old.txt" {
+			t.Fatalf("read #%d: %d %q", i, code, file.Filename)
+		}
+	}
+
+	expectRenameChecks(mock, 5, "text/plain")
+	mock.ExpectQuery(renameQuery).WithArgs("new.txt", 5, cacheTestOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	rec := call(t, RenameFile(db, env.rdb), http.MethodPut, "/files/5/rename", map[string]string{"file_id": "5"},
+		strings.NewReader(`{"new_filename":"new.txt"}`), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename: %d %s", rec.Code, rec.Body)
+	}
+
+	mock.ExpectQuery(metadataQuery).WithArgs(5, cacheTestOwner).WillReturnRows(metadataRows(5, "new.txt", "[]"))
+	if code, file := readMetadata(t, env, "5"); code != http.StatusOK || file.Filename != "new.txt" {
+		t.Fatalf("read after rename: %d %q", code, file.Filename)
+	}
+	env.done()
+}
+
+func TestAnnotationEditInvalidatesMetadataAndTags(t *testing.T) {
+	env := setupCacheTest(t)
+	db, mock := env.db, env.mock
+	tagsQuery := quoted("SELECT tag, COUNT(*) FROM files, unnest(tags)")
+	readTags := func() string {
+		t.Helper()
+		rec := call(t, ListTags(db, env.rdb), http.MethodGet, "/tags", nil, nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("tags: %d %s", rec.Code, rec.Body)
+		}
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	mock.ExpectQuery(metadataQuery).WithArgs(5, cacheTestOwner).WillReturnRows(metadataRows(5, "a.txt", "[]"))
+	readMetadata(t, env, "5")
+	mock.ExpectQuery(tagsQuery).WithArgs(cacheTestOwner).WillReturnRows(sqlmock.NewRows([]string{"tag", "count"}))
+	if got := readTags(); got != "[]" {
+		t.Fatalf("tags before edit = %s", got)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(quoted("SELECT to_json(tags), description, metadata FROM files")).WithArgs(5, cacheTestOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"tags", "description", "metadata"}).AddRow([]byte("[]"), "", []byte("{}")))
+	mock.ExpectExec(quoted("UPDATE files SET tags = $1")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	rec := call(t, AddFileTags(db, env.rdb), http.MethodPost, "/files/5/tags", map[string]string{"file_id": "5"},
+		strings.NewReader(`{"tags":["Budget"]}`), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add tags: %d %s", rec.Code, rec.Body)
+	}
+
+	mock.ExpectQuery(metadataQuery).WithArgs(5, cacheTestOwner).WillReturnRows(metadataRows(5, "a.txt", `["budget"]`))
+	if _, file := readMetadata(t, env, "5"); len(file.Tags) != 1 || file.Tags[0] != "budget" {
+		t.Fatalf("metadata tags after edit = %v", file.Tags)
+	}
+	mock.ExpectQuery(tagsQuery).WithArgs(cacheTestOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "count"}).AddRow("budget", 1))
+	if got := readTags(); got != `[{"tag":"budget","count":1}]` {
+		t.Fatalf("tags after edit = %s", got)
+	}
+	env.done()
+}
+
+func TestFirstShareInvalidatesListings(t *testing.T) {
+	env := setupCacheTest(t)
+	db, mock := env.db, env.mock
+
+	mock.ExpectQuery(listingQuery).WillReturnRows(listingRow(sqlmock.NewRows(listingColumns), 5, "a.txt", false))
+	for i := 0; i < 2; i++ {
+		if files := readListing(t, env); len(files) != 1 || files[0].Shared {
+			t.Fatalf("read #%d: %+v", i, files)
+		}
+	}
+
+	mock.ExpectQuery(quoted("SELECT id, COALESCE(file_url, ''), filename, COALESCE(owner_id, ''), status FROM files")).WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file_url", "filename", "owner_id", "status"}).
+			AddRow(5, "https://bucket.example/a.txt", "a.txt", cacheTestOwner, "active"))
+	mock.ExpectExec(quoted("UPDATE files SET shared_at = NOW()")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := call(t, GetFileShareableURL(db, env.rdb), http.MethodGet, "/files/5/share", map[string]string{"file_id": "5"}, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("share: %d %s", rec.Code, rec.Body)
+	}
+
+	mock.ExpectQuery(listingQuery).WillReturnRows(listingRow(sqlmock.NewRows(listingColumns), 5, "a.txt", true))
+	if files := readListing(t, env); len(files) != 1 || !files[0].Shared {
+		t.Fatalf("read after share: %+v", files)
+	}
+	env.done()
+}
+
+func TestUploadInvalidatesListingsAndRememberedMiss(t *testing.T) {
+	env := setupCacheTest(t)
+	db, mock := env.db, env.mock
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("uploads", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stored := uploadToS3
+	uploadToS3 = func(file io.Reader, fileName, sha256Hex string) (string, error) {
+		io.Copy(io.Discard, file)
+		return "https://bucket.example/" + fileName, nil
+	}
+	t.Cleanup(func() { uploadToS3 = stored })
+
+	// The id the upload will get was looked up before and remembered as missing
+	mock.ExpectQuery(metadataQuery).WithArgs(42, cacheTestOwner).WillReturnRows(sqlmock.NewRows(nil))
+	if code, _ := readMetadata(t, env, "42"); code != http.StatusNotFound {
+		t.Fatalf("read before upload: %d", code)
+	}
+	mock.ExpectQuery(listingQuery).WillReturnRows(sqlmock.NewRows(listingColumns))
+	if files := readListing(t, env); len(files) != 0 {
+		t.Fatalf("listing before upload: %+v", files)
+	}
+
+	quota := quoted("FROM users u LEFT JOIN teams t")
+	quotaColumns := []string{"quota_bytes", "team_id", "team_quota"}
+	mock.ExpectQuery(quota).WillReturnRows(sqlmock.NewRows(quotaColumns))
+	mock.ExpectQuery(quoted("SELECT bytes_used FROM storage_usage")).WillReturnRows(sqlmock.NewRows([]string{"bytes_used"}))
+	mock.ExpectQuery(quoted("SELECT role, team_id FROM users")).WillReturnRows(sqlmock.NewRows([]string{"role", "team_id"}))
+	mock.ExpectQuery(quoted("FROM upload_policies")).WillReturnRows(sqlmock.NewRows([]string{"subject", "allow_types",
+		"deny_types", "allow_extensions", "deny_extensions", "updated_at"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(quota).WillReturnRows(sqlmock.NewRows(quotaColumns))
+	mock.ExpectExec(quoted("INSERT INTO storage_usage (subject) VALUES")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(quoted("SELECT bytes_used FROM storage_usage WHERE subject = $1 FOR UPDATE")).
+		WillReturnRows(sqlmock.NewRows([]string{"bytes_used"}).AddRow(0))
+	mock.ExpectExec(quoted("INSERT INTO storage_usage (subject, bytes_used")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(quoted("INSERT INTO files")).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mock.ExpectCommit()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "notes.txt")
+	part.Write([]byte("hello world"))
+	form.Close()
+	jobs := queue.New(env.rdb, queue.Options{})
+	rec := call(t, UploadFile(db, env.rdb, jobs, nil), http.MethodPost, "/upload", nil, &body,
+		http.Header{"Content-Type": {form.FormDataContentType()}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+
+	mock.ExpectQuery(metadataQuery).WithArgs(42, cacheTestOwner).WillReturnRows(metadataRows(42, "notes.txt", "[]"))
+	if code, file := readMetadata(t, env, "42"); code != http.StatusOK || file.ID != 42 {
+		t.Fatalf("read after upload: %d %+v", code, file)
+	}
+	mock.ExpectQuery(listingQuery).WillReturnRows(listingRow(sqlmock.NewRows(listingColumns), 42, "notes.txt", false))
+	if files := readListing(t, env); len(files) != 1 || files[0].ID != 42 {
+		t.Fatalf("listing after upload: %+v", files)
+	}
+	env.done()
+}
+
+// The namespaces written above must be the ones reads depend on
+func TestInvalidateCacheCoversOwnerAndFiles(t *testing.T) {
+	env := setupCacheTest(t)
+	ctx := t.Context()
+	key := func(ns string) string {
+		k, err := cache.Key(ctx, env.rdb, "k", ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	user, file := key(cache.UserNamespace(cacheTestOwner)), key(cache.FileNamespace(5))
+	invalidateCache(env.rdb, cacheTestOwner, 5)
+	if key(cache.UserNamespace(cacheTestOwner)) == user || key(cache.FileNamespace(5)) == file {
+		t.Fatal("invalidateCache left a namespace at its old version")
+	}
+}
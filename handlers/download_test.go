@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var downloadQuery = quoted("FROM files WHERE filepath = $1")
+
+var downloadColumns = []string{"id", "filename", "owner_id", "sha256", "size", "mime_type", "status"}
+
+// storeUpload writes content under uploads/ in a fresh working directory
+func storeUpload(t *testing.T, storedName, content string) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("uploads", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", storedName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func download(t *testing.T, env *cacheTestEnv, storedName string) (int, http.Header, string) {
+	t.Helper()
+	rec := call(t, DownloadFile(env.db), http.MethodGet, "/download/"+storedName, map[string]string{"filename": storedName}, nil, nil)
+	return rec.Code, rec.Header(), rec.Body.String()
+}
+
+// TestDownloadRenamedFile downloads a file after RenameFile changed its
+// filename: the row is still found by its stored path and the download is
+// offered under the new name
+func TestDownloadRenamedFile(t *testing.T) {
+	env := setupCacheTest(t)
+	storeUpload(t, "1700000000_draft.txt", "hello")
+
+	env.mock.ExpectQuery(downloadQuery).WithArgs("uploads/1700000000_draft.txt").
+		WillReturnRows(sqlmock.NewRows(downloadColumns).
+			AddRow(5, "final.txt", cacheTestOwner, "", 5, "text/plain", "active"))
+	code, header, body := download(t, env, "1700000000_draft.txt")
+	if code != http.StatusOK || body != "hello" {
+		t.Fatalf("download = %d %q", code, body)
+	}
+	if got := header.Get("Content-Disposition"); !strings.Contains(got, `filename="final.txt"`) {
+		t.Fatalf("Content-Disposition = %q, want the new name", got)
+	}
+	env.done()
+}
+
+// TestDownloadServesOnlyTheStoredRow checks that a clean file renamed to the
+// stored name of a quarantined one does not expose the quarantined bytes
+func TestDownloadServesOnlyTheStoredRow(t *testing.T) {
+	env := setupCacheTest(t)
+	storeUpload(t, "1700000000_infected.exe", "malware")
+
+	env.mock.ExpectQuery(downloadQuery).WithArgs("uploads/1700000000_infected.exe").
+		WillReturnRows(sqlmock.NewRows(downloadColumns).
+			AddRow(6, "infected.exe", cacheTestOwner, "", 7, "application/octet-stream", statusQuarantined))
+	code, _, body := download(t, env, "1700000000_infected.exe")
+	if code == http.StatusOK || strings.Contains(body, "malware") {
+		t.Fatalf("quarantined file served: %d %q", code, body)
+	}
+	env.done()
+}
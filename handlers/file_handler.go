@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +15,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
      "strings"
 	 
@@ -24,7 +29,13 @@ import (
 	
 
 	
-	appConfig "github.com/SOMAK939/file-sharing-platform/config" 
+	"github.com/SOMAK939/file-sharing-platform/cache"
+	"github.com/SOMAK939/file-sharing-platform/notify"
+	"github.com/SOMAK939/file-sharing-platform/progress"
+	"github.com/SOMAK939/file-sharing-platform/queue"
+	"github.com/SOMAK939/file-sharing-platform/scan"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/SOMAK939/file-sharing-platform/webhooks"
 
 	
 
@@ -46,10 +57,14 @@ func InitS3() {
 
 // FileMetadata struct
 type FileMetadata struct {
-	ID       int    `json:"id"`
-	Filename string `json:"filename"`
-	Filepath string `json:"filepath"`
-	URL      string `json:"url"`
+	ID          int               `json:"id"`
+	Filename    string            `json:"filename"`
+	Filepath    string            `json:"filepath"`
+	URL         string            `json:"url,omitempty"` // only for active files
+	Status      string            `json:"status,omitempty"` // active, pending_scan or quarantined
+	Tags        []string          `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
 // GetFileURL retrieves file metadata and provides a downloadable link
@@ -76,140 +91,384 @@ func GetFileURL(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// DownloadFile serves the file for download
-func DownloadFile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileName := vars["filename"]
-	filePath := filepath.Join("uploads", fileName)
+// DownloadFile serves the file for download. It supports HEAD, single and multi-part
+// byte ranges (206/416) and conditional requests via ETag and Last-Modified. Files
+// with a recorded SHA-256 get it as their ETag and in Digest/Repr-Digest headers,
+// so clients can verify what they received. Only files with a row, or derivatives
+// of an active file, are served.
+func DownloadFile(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		fileName := vars["filename"]
+		if fileName != filepath.Base(fileName) || fileName == "." || fileName == ".." {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		filePath := filepath.Join("uploads", fileName)
 
-	// Open the file
-	file, err := os.Open(filePath)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-	defer file.Close()
+		// Open the file
+		file, err := os.Open(filePath)
+		if err != nil {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		defer file.Close()
 
-	// Get file info
-	fileStat, err := file.Stat()
-	if err != nil {
-		http.Error(w, "Error retrieving file", http.StatusInternalServerError)
-		return
-	}
+		// Get file info
+		fileStat, err := file.Stat()
+		if err != nil {
+			http.Error(w, "Error retrieving file", http.StatusInternalServerError)
+			return
+		}
+		if fileStat.IsDir() {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
 
-	// Set response headers
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileStat.Name())
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileStat.Size()))
+		// Rows are found by their stored path: filename is the display name,
+		// which RenameFile changes and which need not be unique
+		var id int
+		var displayName, ownerID, checksum, mimeType, status string
+		var recordedSize int64
+		err = db.QueryRow("SELECT id, filename, COALESCE(owner_id, ''), COALESCE(sha256, ''), COALESCE(size, 0), COALESCE(mime_type, ''), status FROM files WHERE filepath = $1", filePath).
+			Scan(&id, &displayName, &ownerID, &checksum, &recordedSize, &mimeType, &status)
+		known := err == nil
+		if err != nil && err != sql.ErrNoRows {
+			log.Println(" Download lookup error:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		// Files waiting for a malware scan or found infected are never served
+		if known && rejectUnavailable(w, status) {
+			return
+		}
+		// Other names are only served if they are a derivative, such as a
+		// thumbnail, of an active file; anything else in uploads/ stays private
+		if !known {
+			err := db.QueryRow(`SELECT d.content_type FROM file_derivatives d JOIN files f ON f.id = d.file_id
+				WHERE d.filepath = $1 AND f.status = 'active' LIMIT 1`, filePath).Scan(&mimeType)
+			if err == sql.ErrNoRows {
+				http.Error(w, "File not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Println(" Download lookup error:", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			displayName = fileStat.Name()
+		}
 
-	// Stream file to response
-	_, err = io.Copy(w, file)
-	if err != nil {
-		http.Error(w, "Error streaming file", http.StatusInternalServerError)
+		// Set response headers; Content-Type is the type detected at upload, and
+		// only files from before detection fall back to ServeContent's guess from
+		// the extension. ServeContent also evaluates If-None-Match/If-Modified-Since/If-Range.
+		if mimeType != "" {
+			w.Header().Set("Content-Type", mimeType)
+		}
+		w.Header().Set("Content-Disposition", utils.ContentDisposition("attachment", utils.DisplayName(displayName)))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		etag := fmt.Sprintf(`"%x-%x"`, fileStat.ModTime().UnixNano(), fileStat.Size())
+		// A size that no longer matches the row means the checksum cannot describe these bytes
+		if checksum != "" && recordedSize == fileStat.Size() {
+			if digest, err := utils.SHA256Base64(checksum); err == nil {
+				etag = `"` + checksum + `"`
+				w.Header().Set("Digest", "sha-256="+digest)
+				w.Header().Set("Repr-Digest", "sha-256=:"+digest+":")
+			}
+		}
+		w.Header().Set("ETag", etag)
+
+		// Stream file (or the requested ranges) to response
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		http.ServeContent(rec, r, fileStat.Name(), fileStat.ModTime(), file)
+
+		// Count a download once: full responses and ranges starting at byte 0,
+		// not every chunk of a resumed download or a cache revalidation
+		if known && r.Method == http.MethodGet && (rec.status == http.StatusOK ||
+			(rec.status == http.StatusPartialContent && strings.HasPrefix(r.Header.Get("Range"), "bytes=0-"))) {
+			actor := optionalUser(r)
+			if actor == ownerID {
+				actor = ""
+			}
+			dispatchWebhook(ownerID, webhooks.EventFileDownloaded, webhooks.FileData{
+				FileID: id, Filename: displayName, Size: fileStat.Size(), Actor: actor,
+			})
+		}
 	}
 }
 
-// UploadFile handles file upload and metadata storage
-func UploadFile(db *sql.DB, uploadQueue chan string) http.HandlerFunc {
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// multipartOverhead is the slack allowed between Content-Length and the file size
+// for multipart boundaries and part headers when pre-checking the quota
+const multipartOverhead = 16 << 10
+
+// UploadFile handles file upload and metadata storage. With a scanner the file
+// is held as pending_scan until the processing job finds it clean.
+func UploadFile(db *sql.DB, RDB *redis.Client, jobQueue *queue.Queue, scanner scan.Scanner) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get JWT token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, " Unauthorized: Missing token", http.StatusUnauthorized)
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
 			return
 		}
 
-		// Extract token (Bearer Token)
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		userID, err := appConfig.ValidateJWT(tokenStr)
+		// Reject before reading the body if the declared size cannot fit the quota
+		remaining, err := utils.RemainingStorage(db, userID)
 		if err != nil {
-			fmt.Println("JWT Validation Error:", err) // 🔍 Debugging line
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+			log.Println(" Quota lookup error:", err)
+			http.Error(w, "Failed to check storage quota", http.StatusInternalServerError)
+			return
+		}
+		if r.ContentLength > remaining+multipartOverhead {
+			quotaErr := &utils.QuotaExceededError{Scope: "storage", Quota: remaining, Requested: r.ContentLength}
+			http.Error(w, quotaErr.Error(), http.StatusRequestEntityTooLarge)
 			return
 		}
-		fmt.Println("Extracted User ID:", userID) // 🔍 Debugging line
 
+		// Track bytes received and stage changes under the client's upload id
+		tracker := progress.Start(r.Context(), progress.NewUploadID(uploadIDFrom(r)), userID, r.ContentLength)
+		w.Header().Set("X-Upload-ID", tracker.ID())
 
-		// Parse the file from request
-		err = r.ParseMultipartForm(10 << 20) // 10MB max
+		// Stream the file part straight to disk instead of buffering the whole form
+		reader, err := r.MultipartReader()
 		if err != nil {
-			http.Error(w, "File too large", http.StatusBadRequest)
+			tracker.Fail("expected multipart/form-data upload")
+			http.Error(w, "Expected multipart/form-data upload", http.StatusBadRequest)
 			return
 		}
-
-		file, handler, err := r.FormFile("file")
+		part, fields, err := nextFilePart(reader, "file")
 		if err != nil {
+			tracker.Fail("failed to read file")
 			http.Error(w, "Failed to read file", http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
+		defer part.Close()
+
+		folder := utils.NormalizeFolder(fields["folder"])
+
+		// Identify the file from its first bytes and check it against its
+		// extension and the uploader's policy before anything is written
+		buffered := bufio.NewReaderSize(part, utils.SniffLength)
+		head, err := buffered.Peek(utils.SniffLength)
+		if err != nil && err != io.EOF {
+			tracker.Fail("failed to read file")
+			http.Error(w, "Failed to read file", http.StatusBadRequest)
+			return
+		}
+		mimeType := utils.DetectContentType(head, part.FileName())
+		if err := utils.CheckExtension(part.FileName(), mimeType); err != nil {
+			tracker.Fail(err.Error())
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		policy, err := utils.LoadUploadPolicy(db, userID)
+		if err != nil {
+			log.Println(" Upload policy lookup error:", err)
+			tracker.Fail("upload policy check failed")
+			http.Error(w, "Failed to check upload policy", http.StatusInternalServerError)
+			return
+		}
+		if err := policy.Check(part.FileName(), mimeType); err != nil {
+			tracker.Fail(err.Error())
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
 
 		// Generate unique filename
-		filename := fmt.Sprintf("%d_%s", time.Now().Unix(), handler.Filename)
+		filename := fmt.Sprintf("%d_%s", time.Now().Unix(), filepath.Base(part.FileName()))
 		filePath := filepath.Join("uploads", filename)
 
-		// Save locally
+		// Save locally, cutting the upload off as soon as it outgrows the quota
 		dst, err := os.Create(filePath)
 		if err != nil {
+			tracker.Fail("could not create file")
 			http.Error(w, "Could not create file", http.StatusInternalServerError)
 			return
 		}
-		defer dst.Close()
-		_, err = io.Copy(dst, file)
-
+		hasher := sha256.New()
+		size, err := io.Copy(io.MultiWriter(dst, hasher), &utils.QuotaReader{R: &progress.Reader{R: buffered, T: tracker}, Limit: remaining})
+		dst.Close()
+		checksum := hex.EncodeToString(hasher.Sum(nil))
 		if err != nil {
+			os.Remove(filePath)
+			var quotaErr *utils.QuotaExceededError
+			if errors.As(err, &quotaErr) {
+				tracker.Fail(quotaErr.Error())
+				http.Error(w, quotaErr.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			tracker.Fail("failed to save file")
 			http.Error(w, "Failed to save file", http.StatusInternalServerError)
 			return
 		}
+		tracker.SetStage(progress.StageStoring)
 
-			// Start a database transaction
-	tx, err := db.Begin()
-	if err != nil {
-		http.Error(w, " Failed to start transaction", http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback() // Rollback in case of failure
+		// Upload to S3 from the saved copy before touching the quota rows, so
+		// their locks are not held for the length of the transfer
+		saved, err := os.Open(filePath)
+		if err != nil {
+			os.Remove(filePath)
+			tracker.Fail("failed to read saved file")
+			http.Error(w, "Failed to read saved file", http.StatusInternalServerError)
+			return
+		}
+		s3URL, err := uploadToS3(&progress.File{File: saved, T: tracker, Size: size}, filename, checksum)
+		saved.Close()
+		if err != nil {
+			os.Remove(filePath)
+			tracker.Fail("storage upload failed")
+			http.Error(w, "S3 upload failed", http.StatusInternalServerError)
+			return
+		}
 
-	// Upload to S3
-	s3URL, err := UploadToS3(file, filename)
-	if err != nil {
-		http.Error(w, "S3 upload failed", http.StatusInternalServerError)
-		return
-	}
+		// discard removes both stored copies when the upload cannot be recorded
+		discard := func() {
+			os.Remove(filePath)
+			if err := utils.DeleteFromS3(s3URL); err != nil {
+				log.Printf(" Failed to delete S3 object of abandoned upload %s: %v\n", filename, err)
+			}
+		}
 
-	// Store metadata in DB using transaction
-	_, err = tx.Exec("INSERT INTO files (filename, filepath, uploaded_at, file_url, owner_id) VALUES ($1, $2, $3, $4, $5)",
-		filename, filePath, time.Now(), s3URL, userID)
+		// Charge the quota and record the file in one short transaction; the
+		// usage rows are locked so concurrent uploads cannot both squeeze in
+		tx, err := db.Begin()
+		if err != nil {
+			discard()
+			tracker.Fail("failed to save file metadata")
+			http.Error(w, " Failed to start transaction", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback() // Rollback in case of failure
 
-	if err != nil {
-		log.Println(" Database insert error:", err) // Log the actual SQL error
-		http.Error(w, "Database insert failed", http.StatusInternalServerError)
-		return
-	}
+		chargedTeam, err := utils.ReserveStorage(tx, userID, size)
+		if err != nil {
+			tx.Rollback()
+			discard()
+			tracker.Fail("storage quota check failed")
+			var quotaErr *utils.QuotaExceededError
+			if errors.As(err, &quotaErr) {
+				http.Error(w, quotaErr.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			log.Println(" Quota reservation error:", err)
+			http.Error(w, "Failed to update storage usage", http.StatusInternalServerError)
+			return
+		}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		http.Error(w, " Failed to commit transaction", http.StatusInternalServerError)
-		return
-	}
+		// Store metadata in DB using transaction. The team charged is kept on the
+		// row so deleting the file credits that team even if the owner moves.
+		var fileID int
+		status := initialFileStatus(scanner)
+		err = tx.QueryRow("INSERT INTO files (filename, filepath, size, uploaded_at, file_url, owner_id, folder, sha256, verified_at, mime_type, status, charged_team) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), $9, $10, $11) RETURNING id",
+			filename, filePath, size, time.Now(), s3URL, userID, folder, checksum, mimeType, status, chargedTeam).Scan(&fileID)
 
-	// Respond
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": " File uploaded successfully",
-		"url":     s3URL,
-	})
-	
+		if err != nil {
+			tx.Rollback()
+			discard()
+			tracker.Fail("failed to save file metadata")
+			log.Println(" Database insert error:", err) // Log the actual SQL error
+			http.Error(w, "Database insert failed", http.StatusInternalServerError)
+			return
+		}
+
+		// Commit the transaction
+		if err = tx.Commit(); err != nil {
+			discard()
+			tracker.Fail("failed to save file metadata")
+			http.Error(w, " Failed to commit transaction", http.StatusInternalServerError)
+			return
+		}
+		tracker.SetFile(fileID, filename)
+		// The file's own namespace too: its id may have been negatively cached
+		invalidateCache(RDB, userID, fileID)
+
+		// A file held for scanning gets no public link until it is released
+		publicURL := ""
+		if status == "active" {
+			publicURL = s3URL
+		}
 
-	// Notify user via WebSocket
-	go NotifyUploadComplete(filename, userID)
+		// Queue post-upload processing; the file is stored either way, so a queue
+		// failure is logged rather than failing the upload
+		response := map[string]string{
+			"message":   " File uploaded successfully",
+			"file_id":   strconv.Itoa(fileID),
+			"upload_id": tracker.ID(),
+			"status":    status,
+		}
+		if publicURL != "" {
+			response["url"] = publicURL
+		}
+		job, err := jobQueue.Enqueue(r.Context(), queue.TypeProcessUpload,
+			queue.ProcessUploadPayload{FileID: fileID, Filename: filename, UploadID: tracker.ID()},
+			queue.Meta{Owner: userID, FileID: fileID})
+		if err != nil {
+			log.Printf(" Failed to queue processing for file %d: %v\n", fileID, err)
+			tracker.SetStage(progress.StageCompleted)
+		} else {
+			response["job_id"] = job.ID
+			tracker.SetStage(progress.StageQueued)
+		}
 
+		// Respond
+		json.NewEncoder(w).Encode(response)
+
+		// Notify user via WebSocket
+		go notify.Publish(userID, notify.NewEvent(notify.TypeUploadCompleted, notify.UploadCompletedPayload{
+			FilePayload: notify.FilePayload{FileID: fileID, Filename: filename},
+			Size:        size,
+			URL:         publicURL,
+			JobID:       response["job_id"],
+		}))
+		dispatchWebhook(userID, webhooks.EventFileUploaded, webhooks.FileData{
+			FileID: fileID, Filename: filename, Size: size, URL: publicURL,
+		})
 
 	}
 }
 
+// maxFormFieldSize caps the text fields read ahead of the file part
+const maxFormFieldSize = 4 << 10
+
+// nextFilePart advances reader to the file part with the given form field name,
+// collecting the plain text fields (such as "folder") sent before it
+func nextFilePart(reader *multipart.Reader, field string) (*multipart.Part, map[string]string, error) {
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, fields, nil
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			if err != nil {
+				part.Close()
+				return nil, nil, err
+			}
+			fields[part.FormName()] = string(value)
+		}
+		part.Close()
+	}
+}
 
+// uploadToS3 is where UploadFile stores files; tests replace it
+var uploadToS3 = UploadToS3
 
-func UploadToS3(file multipart.File, fileName string) (string, error) {
+// UploadToS3 stores file under fileName. When sha256Hex is set S3 verifies the
+// body against it and rejects the upload on a mismatch.
+func UploadToS3(file io.Reader, fileName, sha256Hex string) (string, error) {
 	// Load AWS Config
 	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), awsConfig.WithRegion(os.Getenv("AWS_REGION")))
 	if err != nil {
@@ -221,12 +480,19 @@ func UploadToS3(file multipart.File, fileName string) (string, error) {
 	svc := s3.NewFromConfig(cfg)
 
 	// Upload file
-	_, err = svc.PutObject(context.TODO(), &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(os.Getenv("AWS_S3_BUCKET_NAME")),
 		Key:    aws.String(fileName),
 		Body:   file,
-		
-	})
+	}
+	if sha256Hex != "" {
+		checksum, err := utils.SHA256Base64(sha256Hex)
+		if err != nil {
+			return "", err
+		}
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+	_, err = svc.PutObject(context.TODO(), input)
 	if err != nil {
 		log.Printf(" S3 Upload Error: %v\n", err)
 		return "", err
@@ -243,35 +509,124 @@ func UploadToS3(file multipart.File, fileName string) (string, error) {
 }
 
 
-// ProcessUploads handles background file processing
-func ProcessUploads(uploadQueue chan string) {
-	for filePath := range uploadQueue {
-		fmt.Println("Processing uploaded file:", filePath)
-		time.Sleep(2 * time.Second) // Simulating processing time
-		fmt.Println("File processed successfully:", filePath)
+// ProcessUploadJob handles background file processing: every uploaded file is
+// scanned if it is pending_scan, then clean files get their thumbnails (or PDF
+// preview) generated
+func ProcessUploadJob(db *sql.DB, RDB *redis.Client, scanner scan.Scanner) queue.HandlerFunc {
+	return func(ctx context.Context, job *queue.Job) error {
+		payload, err := queue.Decode[queue.ProcessUploadPayload](job)
+		if err != nil {
+			return err
+		}
+
+		// Nothing else touches the file until it is known to be clean
+		progress.SetStage(ctx, payload.UploadID, progress.StageScanning, "")
+		status, err := scanUpload(ctx, db, RDB, scanner, payload.FileID)
+		if err != nil {
+			return err
+		}
+		if status == statusQuarantined {
+			progress.SetStage(ctx, payload.UploadID, progress.StageQuarantined, "")
+		}
+		if status != "active" {
+			return nil
+		}
+
+		fmt.Println("Processing uploaded file:", payload.FileID)
+		progress.SetStage(ctx, payload.UploadID, progress.StageProcessing, "")
+		generated, err := generateThumbnails(ctx, db, payload.FileID)
+		if err != nil {
+			return fmt.Errorf("processing failed for file %d: %v", payload.FileID, err)
+		}
+		// Search still finds the file by name if its content cannot be read
+		if extracted, err := extractContent(ctx, db, payload.FileID); err != nil {
+			log.Printf(" Text extraction failed for file %d: %v\n", payload.FileID, err)
+		} else if extracted {
+			invalidateCache(RDB, job.Owner, payload.FileID)
+		}
+		fmt.Printf("File processed successfully: %d (%d thumbnails)\n", payload.FileID, generated)
+		progress.SetStage(ctx, payload.UploadID, progress.StageCompleted, "")
+
+		if job.Owner != "" {
+			notify.Publish(job.Owner, notify.NewEvent(notify.TypeProcessingFinished, notify.ProcessingFinishedPayload{
+				FilePayload: notify.FilePayload{FileID: payload.FileID, Filename: payload.Filename},
+				Status:      "succeeded",
+				Thumbnails:  generated,
+			}))
+		}
+		return nil
+	}
+}
+
+// ProcessUploadFailed tells the owner that processing gave up on their file
+func ProcessUploadFailed(ctx context.Context, job *queue.Job, err error) {
+	payload, decodeErr := queue.Decode[queue.ProcessUploadPayload](job)
+	if decodeErr != nil || job.Owner == "" {
+		return
 	}
+	progress.SetStage(ctx, payload.UploadID, progress.StageFailed, err.Error())
+	notify.Publish(job.Owner, notify.NewEvent(notify.TypeProcessingFinished, notify.ProcessingFinishedPayload{
+		FilePayload: notify.FilePayload{FileID: payload.FileID, Filename: payload.Filename},
+		Status:      "failed",
+		Error:       err.Error(),
+	}))
 }
 
 // GetFileShareableURL generates a public URL for a file
-func GetFileShareableURL(db *sql.DB) http.HandlerFunc {
+func GetFileShareableURL(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		fileID := vars["file_id"]
 
-		var fileURL string
-		err := db.QueryRow("SELECT file_url FROM files WHERE id = $1", fileID).Scan(&fileURL)
+		var id int
+		var fileURL, filename, ownerID, status string
+		err := db.QueryRow("SELECT id, COALESCE(file_url, ''), filename, COALESCE(owner_id, ''), status FROM files WHERE id = $1", fileID).
+			Scan(&id, &fileURL, &filename, &ownerID, &status)
 		if err != nil {
 			http.Error(w, "File not found", http.StatusNotFound)
 			return
 		}
+		if rejectUnavailable(w, status) {
+			return
+		}
 
 		json.NewEncoder(w).Encode(map[string]string{"shareable_url": fileURL})
+
+		// Remember that a link went out so listings can filter on shared files;
+		// the first time is when the file counts as shared
+		accessedBy := optionalUser(r)
+		if res, err := db.Exec("UPDATE files SET shared_at = NOW() WHERE id = $1 AND shared_at IS NULL", id); err != nil {
+			log.Println(" Failed to record share:", err)
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			invalidateCache(RDB, ownerID, id)
+			if ownerID != "" {
+				go notify.Publish(ownerID, notify.NewEvent(notify.TypeFileShared, notify.FileSharedPayload{
+					FilePayload: notify.FilePayload{FileID: id, Filename: filename},
+					SharedBy:    accessedBy,
+				}))
+			}
+			actor := accessedBy
+			if actor == ownerID {
+				actor = ""
+			}
+			dispatchWebhook(ownerID, webhooks.EventFileShared, webhooks.FileData{
+				FileID: id, Filename: filename, URL: fileURL, Actor: actor,
+			})
+		}
+
+		// Let the owner know someone else fetched the link
+		if ownerID != "" && accessedBy != ownerID {
+			go notify.Publish(ownerID, notify.NewEvent(notify.TypeShareLinkAccessed, notify.ShareLinkAccessedPayload{
+				FilePayload: notify.FilePayload{FileID: id, Filename: filename},
+				AccessedBy:  accessedBy,
+			}))
+		}
 	}
 }
 
 func GetUploadedFiles(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT id, filename, file_url, uploaded_at FROM files")
+		rows, err := db.Query("SELECT id, filename, file_url, uploaded_at FROM files WHERE status = 'active'")
 		if err != nil {
 			log.Println(" Database query failed:", err)
 			http.Error(w, "Database query failed", http.StatusInternalServerError)
@@ -319,57 +674,22 @@ func GetUploadedFiles(db *sql.DB) http.HandlerFunc {
 }
 
 
-func SearchFiles(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+// RenameFile changes the name one of the caller's files is shown and
+// downloaded under. Other users' files answer 404, as if they did not exist.
+// The new name has to pass the same extension and upload policy checks as an
+// upload of the file's content under that name.
+func RenameFile(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("query")
-		if query == "" {
-			http.Error(w, "query parameter is required", http.StatusBadRequest)
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
 			return
 		}
-
-		cacheKey := fmt.Sprintf("search:%s", query) // Redis cache key
-
-		// 1️⃣ Check Redis cache first
-		cachedData, err := RDB.Get(context.Background(), cacheKey).Result()
-		if err == nil {
-			// If data exists in cache, return it
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(cachedData))
-			return
-		}
-
-		// 2️⃣ If not cached, query the database
-		rows, err := db.Query("SELECT id, filename, file_url FROM files WHERE filename ILIKE $1 OR file_url ILIKE $1", "%"+query+"%")
+		vars := mux.Vars(r)
+		fileID, err := strconv.Atoi(vars["file_id"])
 		if err != nil {
-			http.Error(w, "Database query error", http.StatusInternalServerError)
+			http.Error(w, "Invalid file id", http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
-
-		var results []FileMetadata
-		for rows.Next() {
-			var file FileMetadata
-			if err := rows.Scan(&file.ID, &file.Filename, &file.URL); err != nil {
-				log.Println(" Error scanning row:", err)
-				continue
-			}
-			results = append(results, file)
-		}
-
-		// 3️⃣ Store results in Redis for future searches
-		jsonData, _ := json.Marshal(results)
-		RDB.Set(context.Background(), cacheKey, jsonData, 10*time.Minute) // Cache for 10 min
-
-		// 4️⃣ Return JSON response
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonData)
-	}
-}
-
-func RenameFile(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		fileID := vars["file_id"]
 
 		// Get new filename from request body
 		var requestBody struct {
@@ -379,17 +699,59 @@ func RenameFile(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
+		newName := requestBody.NewFilename
+		if err := utils.ValidateFilename(newName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The content stays the same, so its detected type must suit the new name
+		var mimeType string
+		err = db.QueryRow("SELECT COALESCE(mime_type, '') FROM files WHERE id = $1 AND owner_id = $2 AND status <> 'deleting'",
+			fileID, userID).Scan(&mimeType)
+		if err == sql.ErrNoRows {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println(" Rename lookup error:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if mimeType == "" {
+			mimeType = "application/octet-stream" // uploaded before detection
+		}
+		if err := utils.CheckExtension(newName, mimeType); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		policy, err := utils.LoadUploadPolicy(db, userID)
+		if err != nil {
+			log.Println(" Upload policy lookup error:", err)
+			http.Error(w, "Failed to check upload policy", http.StatusInternalServerError)
+			return
+		}
+		if err := policy.Check(newName, mimeType); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
 
 		// Update filename in DB
-		_, err := db.Exec("UPDATE files SET filename = $1 WHERE id = $2", requestBody.NewFilename, fileID)
+		var id int
+		err = db.QueryRow("UPDATE files SET filename = $1 WHERE id = $2 AND owner_id = $3 AND status <> 'deleting' RETURNING id",
+			newName, fileID, userID).Scan(&id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
+			log.Println(" Rename error:", err)
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
 
-		// Invalidate the cache
-		cacheKey := "file_metadata:" + fileID
-		RDB.Del(context.Background(), cacheKey)
+		// Invalidate the file's metadata and every listing or search that shows it
+		invalidateCache(RDB, userID, fileID)
 
 		// Return success message
 		w.WriteHeader(http.StatusOK)
@@ -398,95 +760,63 @@ func RenameFile(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
 }
 
 
+// GetFileMetadata returns one of the caller's files with its annotations.
+// Other users' files answer 404, as if they did not exist.
 func GetFileMetadata(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		fileID := vars["file_id"]
-		ctx := context.Background()
-
-		// Check Redis cache first
-		cacheKey := "file_metadata:" + fileID
-		cachedMetadata, err := RDB.Get(ctx, cacheKey).Result()
-		if err == nil {
-			// Cache hit! Return cached data
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(cachedMetadata))
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
 			return
 		}
-
-		// Cache miss! Query the database
-		var file FileMetadata
-		err = db.QueryRow("SELECT id, filename, filepath, file_url FROM files WHERE id = $1", fileID).
-			Scan(&file.ID, &file.Filename, &file.Filepath, &file.URL)
+		vars := mux.Vars(r)
+		fileID := vars["file_id"]
+		id, err := strconv.Atoi(fileID)
 		if err != nil {
 			http.Error(w, "File not found", http.StatusNotFound)
 			return
 		}
 
-		// Convert file metadata to JSON
-		jsonMetadata, _ := json.Marshal(file)
-
-		// Store metadata in Redis (expire in 5 minutes)
-		RDB.Set(ctx, cacheKey, jsonMetadata, 5*time.Minute)
-
-		// Return the metadata
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(jsonMetadata)
-	}
-}
-
-func GetUserFiles(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Get JWT token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, " Unauthorized: Missing token", http.StatusUnauthorized)
-			return
-		}
-
-		// Extract token (Bearer Token)
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		userID, err := appConfig.ValidateJWT(tokenStr)
-		if err != nil {
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		// Check Redis cache
-		cacheKey := fmt.Sprintf("user:files:%s", userID)
-		cachedData, err := RDB.Get(context.TODO(), cacheKey).Result()
-		if err == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(cachedData))
+		// Cached per caller for 5 minutes; unknown ids are remembered briefly so
+		// they do not reach Postgres on every request
+		jsonMetadata, err := cache.Fetch(r.Context(), RDB, "file_metadata:"+userID+":"+fileID, cache.Options{
+			TTL:         5 * time.Minute,
+			NegativeTTL: 30 * time.Second,
+			Namespaces:  []string{cache.FileNamespace(id)},
+		}, func(ctx context.Context) ([]byte, error) {
+			var file FileMetadata
+			var tags, metadata []byte
+			err := db.QueryRowContext(ctx, `SELECT id, filename, filepath,
+				       CASE WHEN status = 'active' THEN COALESCE(file_url, '') ELSE '' END, status, to_json(tags), description, metadata
+				FROM files WHERE id = $1 AND owner_id = $2 AND status <> 'deleting'`, id, userID).
+				Scan(&file.ID, &file.Filename, &file.Filepath, &file.URL, &file.Status, &tags, &file.Description, &metadata)
+			if err == sql.ErrNoRows {
+				return nil, cache.ErrNotFound
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(tags, &file.Tags); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(metadata, &file.Metadata); err != nil {
+				return nil, err
+			}
+			return json.Marshal(file)
+		})
+		if err == cache.ErrNotFound {
+			http.Error(w, "File not found", http.StatusNotFound)
 			return
 		}
-
-		// Fetch user files from DB
-		rows, err := db.Query("SELECT id, filename, file_url FROM files WHERE owner_id = $1 ORDER BY id DESC", userID)
 		if err != nil {
-			http.Error(w, " Database error", http.StatusInternalServerError)
-			log.Println(" Database query error:", err)  // Debugging log
+			log.Println(" Metadata query error:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var files []FileMetadata
-		for rows.Next() {
-			var file FileMetadata
-			if err := rows.Scan(&file.ID, &file.Filename, &file.URL); err != nil {
-				http.Error(w, " Error scanning row", http.StatusInternalServerError)
-				return
-			}
-			files = append(files, file)
-		}
-
-		// Convert result to JSON and cache it
-		fileMetaJSON, _ := json.Marshal(files)
-		RDB.Set(context.TODO(), cacheKey, fileMetaJSON, 5*time.Minute)
 
 		w.Header().Set("Content-Type", "application/json")
-		w.Write(fileMetaJSON)
+		w.Write(jsonMetadata)
 	}
 }
 
 
+
@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/cache"
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sort orders accepted by ?sort=
+const (
+	SortName      = "name"
+	SortSize      = "size"
+	SortDate      = "date"
+	SortRelevance = "relevance" // search only
+)
+
+// sortExpressions are the SQL keys behind each sort order. Every order is broken
+// by id so the (key, id) pair a cursor carries is unique.
+var sortExpressions = map[string]string{
+	SortName:      `f.name_key`,
+	SortSize:      `f.size`,
+	SortDate:      `COALESCE(f.uploaded_at, 'epoch'::timestamp)`,
+	SortRelevance: `ts_rank_cd(f.search_vector, q.query) + word_similarity(q.text, f.name_key)`,
+}
+
+// sortKeyCasts type the cursor parameter the same as the sort key
+var sortKeyCasts = map[string]string{
+	SortName:      "text",
+	SortSize:      "bigint",
+	SortDate:      "timestamp",
+	SortRelevance: "real",
+}
+
+// fuzzyThreshold is the word similarity (0-1) a name needs to match a search
+// it does not contain; lower values tolerate more typos
+func fuzzyThreshold() float64 {
+	return config.GetEnvFloat("SEARCH_FUZZY_THRESHOLD", 0.3)
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// FileSummary describes a file in listings and search results
+type FileSummary struct {
+	ID          int               `json:"id"`
+	Filename    string            `json:"filename"`
+	URL         string            `json:"url,omitempty"` // only for active files
+	Size        int64             `json:"size"`
+	MimeType    string            `json:"mime_type,omitempty"`
+	Folder      string            `json:"folder,omitempty"`
+	Tags        []string          `json:"tags"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Owner       string            `json:"owner"`
+	Shared      bool              `json:"shared"`
+	Status      string            `json:"status"` // active, pending_scan or quarantined
+	UploadedAt  *time.Time        `json:"uploaded_at,omitempty"`
+}
+
+// FileListResponse is one page of GET /user/files
+type FileListResponse struct {
+	Files      []FileSummary `json:"files"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// fileQuery holds the filters, sort order and page requested for a listing
+type fileQuery struct {
+	MimeType       string // exact, or "image/*" for a whole type
+	MinSize        *int64
+	MaxSize        *int64
+	UploadedAfter  *time.Time // inclusive
+	UploadedBefore *time.Time // exclusive
+	Owner          string
+	Folder         string            // includes subfolders
+	Tags           []string          // all must be present
+	Metadata       map[string]string // ?meta.<key>=<value>, all must match
+	Shared         *bool
+	Sort           string
+	Desc           bool
+	Limit          int
+	Cursor         *fileCursor
+	rawCursor      string
+}
+
+// fileCursor is the position after the last row of a page. It is handed to
+// clients base64 encoded and only valid with the same sort order.
+type fileCursor struct {
+	Sort string          `json:"s"`
+	Desc bool            `json:"d"`
+	Key  json.RawMessage `json:"k"`
+	ID   int             `json:"i"`
+}
+
+func (c *fileCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFileCursor(raw string) (*fileCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c fileCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if _, ok := sortExpressions[c.Sort]; !ok || len(c.Key) == 0 {
+		return nil, fmt.Errorf("unknown sort %q", c.Sort)
+	}
+	// Reject a key of the wrong type here, as a bad request, rather than when
+	// the query is built
+	if _, err := c.keyParam(); err != nil {
+		return nil, fmt.Errorf("invalid cursor key: %v", err)
+	}
+	return &c, nil
+}
+
+// keyParam converts the cursor's sort key back into a query parameter
+func (c *fileCursor) keyParam() (any, error) {
+	switch c.Sort {
+	case SortName:
+		var s string
+		err := json.Unmarshal(c.Key, &s)
+		return s, err
+	case SortSize:
+		var n int64
+		err := json.Unmarshal(c.Key, &n)
+		return n, err
+	case SortRelevance:
+		var f float64
+		err := json.Unmarshal(c.Key, &f)
+		return f, err
+	default:
+		// to_json renders timestamps without a zone
+		var s string
+		if err := json.Unmarshal(c.Key, &s); err != nil {
+			return nil, err
+		}
+		return time.Parse("2006-01-02T15:04:05.999999999", s)
+	}
+}
+
+// parseFileQuery reads listing parameters from the query string. Sort orders
+// default to name ascending and everything else descending.
+func parseFileQuery(r *http.Request, defaultSort string, allowRelevance bool) (*fileQuery, error) {
+	values := r.URL.Query()
+	q := &fileQuery{
+		MimeType: strings.ToLower(strings.TrimSpace(values.Get("mime_type"))),
+		Owner:    strings.TrimSpace(values.Get("owner")),
+		Limit:    defaultPageSize,
+	}
+	if len(values["tag"]) > 0 {
+		tags, err := utils.NormalizeTags(values["tag"])
+		if err != nil {
+			return nil, err
+		}
+		q.Tags = tags
+	}
+	for name, vals := range values {
+		key, ok := strings.CutPrefix(name, "meta.")
+		if !ok {
+			continue
+		}
+		if err := utils.ValidateMetadataKey(key); err != nil {
+			return nil, err
+		}
+		if q.Metadata == nil {
+			q.Metadata = make(map[string]string)
+		}
+		q.Metadata[key] = vals[0]
+	}
+	if folder := values.Get("folder"); folder != "" {
+		q.Folder = utils.NormalizeFolder(folder)
+	}
+
+	for name, dst := range map[string]**int64{"min_size": &q.MinSize, "max_size": &q.MaxSize} {
+		if raw := values.Get(name); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative number of bytes", name)
+			}
+			*dst = &n
+		}
+	}
+	for name, dst := range map[string]**time.Time{"uploaded_after": &q.UploadedAfter, "uploaded_before": &q.UploadedBefore} {
+		if raw := values.Get(name); raw != "" {
+			t, err := parseTimeParam(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+			}
+			*dst = &t
+		}
+	}
+	if raw := values.Get("shared"); raw != "" {
+		shared, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("shared must be true or false")
+		}
+		q.Shared = &shared
+	}
+
+	q.Sort = strings.ToLower(values.Get("sort"))
+	if q.Sort == "" {
+		q.Sort = defaultSort
+	}
+	if _, ok := sortExpressions[q.Sort]; !ok || (q.Sort == SortRelevance && !allowRelevance) {
+		return nil, fmt.Errorf("unsupported sort %q", q.Sort)
+	}
+	switch strings.ToLower(values.Get("order")) {
+	case "":
+		q.Desc = q.Sort != SortName
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = n
+	}
+	if raw := values.Get("cursor"); raw != "" {
+		cursor, err := decodeFileCursor(raw)
+		if err != nil || cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return nil, fmt.Errorf("invalid cursor for this sort order")
+		}
+		q.Cursor, q.rawCursor = cursor, raw
+	}
+	return q, nil
+}
+
+func parseTimeParam(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// cacheKey identifies the page in Redis. It is built from the parsed values so
+// equivalent requests share an entry.
+func (q *fileQuery) cacheKey(prefix, userID, search string) string {
+	values := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	set("q", search)
+	set("mime_type", q.MimeType)
+	set("owner", q.Owner)
+	set("folder", q.Folder)
+	set("tag", strings.Join(q.Tags, ","))
+	if len(q.Metadata) > 0 {
+		meta, _ := json.Marshal(q.Metadata) // map keys are sorted
+		set("meta", string(meta))
+	}
+	if q.MinSize != nil {
+		set("min_size", strconv.FormatInt(*q.MinSize, 10))
+	}
+	if q.MaxSize != nil {
+		set("max_size", strconv.FormatInt(*q.MaxSize, 10))
+	}
+	if q.UploadedAfter != nil {
+		set("uploaded_after", q.UploadedAfter.Format(time.RFC3339Nano))
+	}
+	if q.UploadedBefore != nil {
+		set("uploaded_before", q.UploadedBefore.Format(time.RFC3339Nano))
+	}
+	if q.Shared != nil {
+		set("shared", strconv.FormatBool(*q.Shared))
+	}
+	set("sort", q.Sort)
+	set("desc", strconv.FormatBool(q.Desc))
+	set("limit", strconv.Itoa(q.Limit))
+	set("cursor", q.rawCursor)
+	return fmt.Sprintf("%s:%s:%s", prefix, userID, values.Encode())
+}
+
+// queryArgs collects positional parameters while a statement is assembled
+type queryArgs []any
+
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// listFiles returns one page of q and the cursor for the next page, or "" on
+// the last one. A non-empty search restricts the rows to full-text matches and
+// fills in their rank and snippet.
+func listFiles(ctx context.Context, db *sql.DB, q *fileQuery, search string) ([]SearchResult, string, error) {
+	var args queryArgs
+	rankExpr, headlineExpr, with, from, join := "0::real", "''", "", "files f", ""
+	searchParam := ""
+	if search != "" {
+		searchParam = args.add(search)
+		with = fmt.Sprintf(`WITH q AS (
+			SELECT websearch_to_tsquery('simple', %[1]s) || websearch_to_tsquery('english', %[1]s) AS query, %[1]s::text AS text
+		), `, searchParam)
+		from, join = "files f, q", ", q"
+		rankExpr = sortExpressions[SortRelevance]
+		headlineExpr = fmt.Sprintf("ts_headline('english', COALESCE(NULLIF(f.content_text, ''), f.description), q.query, %s)",
+			args.add(fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=30, MinWords=10, MaxFragments=2", headlineStart, headlineStop)))
+	} else {
+		with = "WITH "
+	}
+
+	conds := []string{"f.status <> 'deleting'", "f.owner_id = " + args.add(q.Owner)}
+	if search != "" {
+		// Names also match on trigram similarity, so typos and partial words still hit
+		conds = append(conds, fmt.Sprintf("(f.search_vector @@ q.query OR %s::text <%% f.name_key)", searchParam))
+	}
+	if strings.HasSuffix(q.MimeType, "/*") {
+		conds = append(conds, "f.mime_type LIKE "+args.add(strings.TrimSuffix(q.MimeType, "*")+"%"))
+	} else if q.MimeType != "" {
+		conds = append(conds, "f.mime_type = "+args.add(q.MimeType))
+	}
+	if q.MinSize != nil {
+		conds = append(conds, "f.size >= "+args.add(*q.MinSize))
+	}
+	if q.MaxSize != nil {
+		conds = append(conds, "f.size <= "+args.add(*q.MaxSize))
+	}
+	if q.UploadedAfter != nil {
+		conds = append(conds, "f.uploaded_at >= "+args.add(*q.UploadedAfter))
+	}
+	if q.UploadedBefore != nil {
+		conds = append(conds, "f.uploaded_at < "+args.add(*q.UploadedBefore))
+	}
+	if q.Folder != "" {
+		p := args.add(q.Folder)
+		conds = append(conds, fmt.Sprintf("(f.folder = %[1]s OR starts_with(f.folder, %[1]s || '/'))", p))
+	}
+	if len(q.Tags) > 0 {
+		conds = append(conds, "f.tags @> "+args.add(q.Tags)+"::text[]")
+	}
+	if len(q.Metadata) > 0 {
+		meta, err := json.Marshal(q.Metadata)
+		if err != nil {
+			return nil, "", err
+		}
+		conds = append(conds, "f.metadata @> "+args.add(string(meta))+"::jsonb")
+	}
+	if q.Shared != nil {
+		if *q.Shared {
+			conds = append(conds, "f.shared_at IS NOT NULL")
+		} else {
+			conds = append(conds, "f.shared_at IS NULL")
+		}
+	}
+
+	sortExpr := sortExpressions[q.Sort]
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if q.Cursor != nil {
+		key, err := q.Cursor.keyParam()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor key: %v", err)
+		}
+		conds = append(conds, fmt.Sprintf("(%s, f.id) %s (%s::%s, %s)",
+			sortExpr, cmp, args.add(key), sortKeyCasts[q.Sort], args.add(q.Cursor.ID)))
+	}
+
+	// One extra row tells whether there is a next page
+	statement := fmt.Sprintf(`%spage AS (
+			SELECT f.id, %[2]s AS rank, to_json(%[3]s) AS sort_key,
+			       row_number() OVER (ORDER BY %[3]s %[4]s, f.id %[4]s) AS pos
+			FROM %[5]s
+			WHERE %[6]s
+			ORDER BY %[3]s %[4]s, f.id %[4]s
+			LIMIT %[7]s
+		)
+		SELECT f.id, f.filename, CASE WHEN f.status = 'active' THEN COALESCE(f.file_url, '') ELSE '' END, f.size, COALESCE(f.mime_type, ''), f.folder,
+		       to_json(f.tags), f.description, f.metadata, COALESCE(f.owner_id, ''), f.shared_at IS NOT NULL, f.uploaded_at,
+		       f.status, page.rank, page.sort_key, %[8]s
+		FROM page JOIN files f ON f.id = page.id%[9]s
+		ORDER BY page.pos`,
+		with, rankExpr, sortExpr, dir, from, strings.Join(conds, " AND "), args.add(q.Limit+1), headlineExpr, join)
+
+	var rows *sql.Rows
+	var err error
+	if search != "" {
+		// The similarity threshold is a setting, scoped to a transaction so it
+		// does not leak to other users of the pooled connection
+		var tx *sql.Tx
+		if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+			return nil, "", err
+		}
+		defer tx.Rollback()
+		threshold := strconv.FormatFloat(fuzzyThreshold(), 'f', -1, 64)
+		if _, err := tx.ExecContext(ctx, "SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)", threshold); err != nil {
+			return nil, "", err
+		}
+		rows, err = tx.QueryContext(ctx, statement, args...)
+	} else {
+		rows, err = db.QueryContext(ctx, statement, args...)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	var lastKey json.RawMessage
+	for rows.Next() {
+		var res SearchResult
+		var tags, metadata, sortKey []byte
+		if err := rows.Scan(&res.ID, &res.Filename, &res.URL, &res.Size, &res.MimeType, &res.Folder,
+			&tags, &res.Description, &metadata, &res.Owner, &res.Shared, &res.UploadedAt,
+			&res.Status, &res.Rank, &sortKey, &res.Snippet); err != nil {
+			return nil, "", err
+		}
+		if err := json.Unmarshal(tags, &res.Tags); err != nil {
+			return nil, "", err
+		}
+		if err := json.Unmarshal(metadata, &res.Metadata); err != nil {
+			return nil, "", err
+		}
+		res.Snippet = highlight(res.Snippet)
+		if len(results) == q.Limit {
+			next := &fileCursor{Sort: q.Sort, Desc: q.Desc, Key: lastKey, ID: results[len(results)-1].ID}
+			return results, next.encode(), rows.Err()
+		}
+		results = append(results, res)
+		lastKey = sortKey
+	}
+	return results, "", rows.Err()
+}
+
+// invalidateCache drops every cached listing, search and suggestion of owner and
+// the cached metadata of fileIDs. Call it once the write has committed.
+func invalidateCache(RDB *redis.Client, owner string, fileIDs ...int) {
+	namespaces := make([]string, 0, len(fileIDs)+1)
+	if owner != "" {
+		namespaces = append(namespaces, cache.UserNamespace(owner))
+	}
+	for _, id := range fileIDs {
+		namespaces = append(namespaces, cache.FileNamespace(id))
+	}
+	if err := cache.Invalidate(context.Background(), RDB, namespaces...); err != nil {
+		log.Printf(" Cache invalidation failed for %v: %v\n", namespaces, err)
+	}
+}
+
+// resolveOwner applies the ?owner= filter. Callers list their own files by
+// default; only admins may list another user's.
+func resolveOwner(db *sql.DB, w http.ResponseWriter, userID string, q *fileQuery) bool {
+	if q.Owner == "" || q.Owner == userID {
+		q.Owner = userID
+		return true
+	}
+	admin, err := isAdmin(db, userID)
+	if err != nil {
+		log.Println(" Role lookup error:", err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	if !admin {
+		http.Error(w, "Forbidden: cannot list another user's files", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// GetUserFiles lists the caller's files a page at a time, newest first unless
+// ?sort= (name, size, date) and ?order= say otherwise. Filters: mime_type
+// ("image/png" or "image/*"), min_size/max_size in bytes, uploaded_after/
+// uploaded_before, folder, tag (repeatable, all must match), meta.<key>=<value>,
+// shared and, for admins, owner. Pass the response's next_cursor as ?cursor= to
+// fetch the following page.
+func GetUserFiles(db *sql.DB, RDB *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+		q, err := parseFileQuery(r, SortDate, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !resolveOwner(db, w, userID, q) {
+			return
+		}
+
+		fileMetaJSON, err := cache.Fetch(r.Context(), RDB, q.cacheKey("user:files", userID, ""), cache.Options{
+			TTL:        5 * time.Minute,
+			Namespaces: []string{cache.UserNamespace(q.Owner)},
+		}, func(ctx context.Context) ([]byte, error) {
+			results, next, err := listFiles(ctx, db, q, "")
+			if err != nil {
+				return nil, err
+			}
+			resp := FileListResponse{Files: make([]FileSummary, len(results)), NextCursor: next}
+			for i, res := range results {
+				resp.Files[i] = res.FileSummary
+			}
+			return json.Marshal(resp)
+		})
+		if err != nil {
+			log.Println(" Database query error:", err)
+			http.Error(w, " Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(fileMetaJSON)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/SOMAK939/file-sharing-platform/queue"
+	"github.com/gorilla/mux"
+)
+
+// GetJobStatus reports the state of one of the caller's background jobs
+func GetJobStatus(jobQueue *queue.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		status, err := jobQueue.GetStatus(r.Context(), mux.Vars(r)["job_id"])
+		if err != nil {
+			log.Println(" Job status lookup error:", err)
+			http.Error(w, "Failed to load job status", http.StatusInternalServerError)
+			return
+		}
+		if status == nil || status.Owner != userID {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// GetFileJobs lists the recent background jobs for one of the caller's files,
+// so clients can tell whether processing has finished
+func GetFileJobs(db *sql.DB, jobQueue *queue.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		fileID, err := strconv.Atoi(mux.Vars(r)["file_id"])
+		if err != nil {
+			http.Error(w, "Invalid file id", http.StatusBadRequest)
+			return
+		}
+		var owner string
+		err = db.QueryRow("SELECT COALESCE(owner_id, '') FROM files WHERE id = $1", fileID).Scan(&owner)
+		if err != nil || owner != userID {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+
+		statuses, err := jobQueue.FileStatuses(r.Context(), fileID)
+		if err != nil {
+			log.Println(" Job status lookup error:", err)
+			http.Error(w, "Failed to load job status", http.StatusInternalServerError)
+			return
+		}
+
+		processed := len(statuses) > 0 && statuses[0].State == queue.StateSucceeded
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"file_id":   fileID,
+			"processed": processed,
+			"jobs":      statuses,
+		})
+	}
+}
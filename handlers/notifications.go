@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/SOMAK939/file-sharing-platform/notify"
+	"github.com/gorilla/mux"
+)
+
+// ListNotifications returns the caller's notification inbox, newest first.
+// Query parameters: unread=true, limit (default 50, max 200) and before=<event id>.
+func ListNotifications() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		limit := 50
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 200)
+		}
+
+		page, err := notify.ListInbox(r.Context(), userID, q.Get("before"), limit, q.Get("unread") == "true")
+		if err != nil {
+			log.Println(" Inbox lookup error:", err)
+			http.Error(w, "Failed to load notifications", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// MarkNotificationRead marks one notification in the caller's inbox as read
+func MarkNotificationRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		found, err := notify.MarkRead(r.Context(), userID, mux.Vars(r)["event_id"])
+		if err != nil {
+			log.Println(" Mark read error:", err)
+			http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Notification not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MarkAllNotificationsRead marks every notification in the caller's inbox as read
+func MarkAllNotificationsRead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if err := notify.MarkAllRead(r.Context(), userID); err != nil {
+			log.Println(" Mark all read error:", err)
+			http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/SOMAK939/file-sharing-platform/progress"
+	"github.com/gorilla/mux"
+)
+
+// uploadIDFrom returns the upload id the client chose, from the X-Upload-ID header
+// or the upload_id query parameter, so it can watch progress before the upload ends
+func uploadIDFrom(r *http.Request) string {
+	if id := r.Header.Get("X-Upload-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("upload_id")
+}
+
+// GetUploadProgress reports bytes received, bytes sent to S3 and the processing
+// stage of one of the caller's uploads
+func GetUploadProgress() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		p, err := progress.Get(r.Context(), mux.Vars(r)["upload_id"])
+		if err != nil {
+			log.Println(" Progress lookup error:", err)
+			http.Error(w, "Failed to load upload progress", http.StatusInternalServerError)
+			return
+		}
+		if p == nil || p.Owner != userID {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/SOMAK939/file-sharing-platform/utils"
+)
+
+// GetUserUsage reports the caller's storage usage against their user and team quotas
+func GetUserUsage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		usage, err := utils.GetStorageUsage(db, userID)
+		if err != nil {
+			log.Println(" Usage lookup error:", err)
+			http.Error(w, "Failed to load storage usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user_id": userID,
+			"usage":   usage,
+		})
+	}
+}
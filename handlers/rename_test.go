@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+var (
+	renameLookupQuery = quoted("SELECT COALESCE(mime_type, '') FROM files")
+	renameQuery       = quoted("UPDATE files SET filename = $1")
+)
+
+func rename(t *testing.T, env *cacheTestEnv, id, newName string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(map[string]string{"new_filename": newName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return call(t, RenameFile(env.db, env.rdb), http.MethodPut, "/files/"+id+"/rename", map[string]string{"file_id": id},
+		bytes.NewReader(body), nil)
+}
+
+// expectRenameChecks expects the lookup of file id's detected type and of the
+// caller's upload policy, of which none is stored
+func expectRenameChecks(mock sqlmock.Sqlmock, id int, mimeType string) {
+	mock.ExpectQuery(renameLookupQuery).WithArgs(id, cacheTestOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"mime_type"}).AddRow(mimeType))
+	mock.ExpectQuery(quoted("FROM users WHERE email = $1")).WithArgs(cacheTestOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "team_id"}))
+	mock.ExpectQuery(quoted("FROM upload_policies")).
+		WillReturnRows(sqlmock.NewRows([]string{"subject"}))
+}
+
+func TestRenameRequiresAuthentication(t *testing.T) {
+	env := setupCacheTest(t)
+	req := httptest.NewRequest(http.MethodPut, "/files/5/rename", strings.NewReader(`{"new_filename":"new.txt"}`))
+	req = mux.SetURLVars(req, map[string]string{"file_id": "5"})
+	rec := httptest.NewRecorder()
+	RenameFile(env.db, env.rdb)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous rename = %d, want 401", rec.Code)
+	}
+	env.done()
+}
+
+// TestRenameOtherUsersFile checks that a file the caller does not own answers
+// 404, the same as one that does not exist
+func TestRenameOtherUsersFile(t *testing.T) {
+	env := setupCacheTest(t)
+	env.mock.ExpectQuery(renameLookupQuery).WithArgs(5, cacheTestOwner).
+		WillReturnRows(sqlmock.NewRows([]string{"mime_type"}))
+	if rec := rename(t, env, "5", "new.txt"); rec.Code != http.StatusNotFound {
+		t.Fatalf("rename = %d %s, want 404", rec.Code, rec.Body)
+	}
+	env.done()
+}
+
+func TestRenameRejectsUnsafeNames(t *testing.T) {
+	env := setupCacheTest(t)
+	for _, name := range []string{
+		"",
+		"   ",
+		"../../etc/passwd",
+		"dir/file.txt",
+		`dir\file.txt`,
+		"..",
+		"a..b.txt",
+		"line\nbreak.txt",
+		"nul\x00.txt",
+		strings.Repeat("a", 256),
+	} {
+		if rec := rename(t, env, "5", name); rec.Code != http.StatusBadRequest {
+			t.Errorf("rename to %q = %d, want 400", name, rec.Code)
+		}
+	}
+	env.done() // rejected before any query
+}
+
+func TestRenameChecksContentAndPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		newName  string
+		want     int
+	}{
+		{"same kind of file", "application/pdf", "final report.pdf", http.StatusOK},
+		{"text under another text extension", "text/markdown", "notes.txt", http.StatusOK},
+		{"pdf under an image extension", "application/pdf", "report.png", http.StatusUnsupportedMediaType},
+		{"document under an executable extension", "application/pdf", "report.exe", http.StatusUnsupportedMediaType},
+		{"executable under a document extension", "application/x-msdownload", "setup.pdf", http.StatusUnsupportedMediaType},
+		{"legacy row under a verifiable extension", "", "old.pdf", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupCacheTest(t)
+			if tt.want == http.StatusOK {
+				expectRenameChecks(env.mock, 5, tt.mimeType)
+				env.mock.ExpectQuery(renameQuery).WithArgs(tt.newName, 5, cacheTestOwner).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+			} else {
+				env.mock.ExpectQuery(renameLookupQuery).WithArgs(5, cacheTestOwner).
+					WillReturnRows(sqlmock.NewRows([]string{"mime_type"}).AddRow(tt.mimeType))
+			}
+			if rec := rename(t, env, "5", tt.newName); rec.Code != tt.want {
+				t.Fatalf("rename to %q = %d %s, want %d", tt.newName, rec.Code, rec.Body, tt.want)
+			}
+			env.done()
+		})
+	}
+}
+
+// TestRenameAppliesUploadPolicy checks that a name the caller's policy would
+// refuse at upload is refused on rename too
+func TestRenameAppliesUploadPolicy(t *testing.T) {
+	env := setupCacheTest(t)
+	t.Setenv("UPLOAD_DENY_EXTENSIONS", "md")
+	expectRenameChecks(env.mock, 5, "text/plain")
+	if rec := rename(t, env, "5", "notes.md"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("rename = %d %s, want 415", rec.Code, rec.Body)
+	}
+	env.done()
+}
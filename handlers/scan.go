@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/notify"
+	"github.com/SOMAK939/file-sharing-platform/scan"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// File statuses set by malware scanning, alongside 'active' and 'deleting'
+const (
+	statusPendingScan = "pending_scan"
+	statusQuarantined = "quarantined"
+)
+
+// HeldFile is a file held back from download by scanning, as listed to admins
+type HeldFile struct {
+	FileID     int        `json:"file_id"`
+	Filename   string     `json:"filename"`
+	Owner      string     `json:"owner"`
+	Size       int64      `json:"size"`
+	Status     string     `json:"status"`
+	Signature  string     `json:"signature,omitempty"`
+	Error      string     `json:"error,omitempty"` // why the last scan reached no verdict
+	ScannedBy  string     `json:"scanned_by,omitempty"`
+	ScannedAt  *time.Time `json:"scanned_at,omitempty"`
+	UploadedAt *time.Time `json:"uploaded_at,omitempty"`
+}
+
+// rejectUnavailable answers for a file whose status keeps it from being
+// downloaded or shared, reporting whether it did
+func rejectUnavailable(w http.ResponseWriter, status string) bool {
+	switch status {
+	case "active":
+		return false
+	case statusPendingScan:
+		http.Error(w, "File is still being scanned for malware", http.StatusConflict)
+	case statusQuarantined:
+		http.Error(w, "File has been quarantined", http.StatusForbidden)
+	default:
+		http.Error(w, "File not found", http.StatusNotFound)
+	}
+	return true
+}
+
+// initialFileStatus is the status new uploads start in: held for scanning when
+// a scanner is configured
+func initialFileStatus(scanner scan.Scanner) string {
+	if scanner == nil {
+		return "active"
+	}
+	return statusPendingScan
+}
+
+// scanUpload scans a file that is pending_scan and releases or quarantines it,
+// returning the file's resulting status ("" if it no longer exists). Files
+// already scanned are not scanned again, so retried jobs skip this step.
+func scanUpload(ctx context.Context, db *sql.DB, RDB *redis.Client, scanner scan.Scanner, fileID int) (string, error) {
+	var status, filename, filePath, fileURL, owner string
+	err := db.QueryRowContext(ctx, `SELECT status, filename, filepath, COALESCE(file_url, ''), COALESCE(owner_id, '')
+		FROM files WHERE id = $1`, fileID).Scan(&status, &filename, &filePath, &fileURL, &owner)
+	if err == sql.ErrNoRows {
+		return "", nil // deleted before we got to it
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load file %d: %v", fileID, err)
+	}
+	if status != statusPendingScan {
+		return status, nil
+	}
+	if scanner == nil {
+		return "", fmt.Errorf("file %d is waiting for a scan but no scanner is configured", fileID)
+	}
+
+	obj, err := utils.OpenStoredFile(ctx, filePath, fileURL)
+	if err != nil {
+		return "", err
+	}
+	result, err := scanner.Scan(ctx, obj)
+	obj.Close()
+	if err != nil {
+		if _, dbErr := db.ExecContext(ctx, "UPDATE files SET scan_error = $2, scanned_by = $3 WHERE id = $1",
+			fileID, err.Error(), scanner.Name()); dbErr != nil {
+			log.Println(" Failed to record scan error:", dbErr)
+		}
+		return "", fmt.Errorf("scan failed for file %d: %v", fileID, err)
+	}
+
+	next := "active"
+	if result.Infected {
+		next = statusQuarantined
+	}
+	res, err := db.ExecContext(ctx, `UPDATE files
+		SET status = $2, scan_signature = NULLIF($3, ''), scan_error = NULL, scanned_by = $4, scanned_at = NOW()
+		WHERE id = $1 AND status = $5`, fileID, next, result.Signature, scanner.Name(), statusPendingScan)
+	if err != nil {
+		return "", fmt.Errorf("failed to record scan result for file %d: %v", fileID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", nil // removed while we were scanning
+	}
+	invalidateCache(RDB, owner, fileID)
+
+	if result.Infected {
+		log.Printf(" File %d quarantined: %s found by %s\n", fileID, result.Signature, scanner.Name())
+		withdrawS3Object(ctx, db, fileID, fileURL)
+		if owner != "" {
+			notify.Publish(owner, notify.NewEvent(notify.TypeFileQuarantined, notify.FileQuarantinedPayload{
+				FilePayload: notify.FilePayload{FileID: fileID, Filename: filename},
+				Signature:   result.Signature,
+				Scanner:     scanner.Name(),
+			}))
+		}
+	}
+	return next, nil
+}
+
+// withdrawS3Object deletes a quarantined file's public S3 object, since its URL
+// works without going through the API. The local copy stays for inspection. If
+// the delete fails the URL is kept, so cleanup still removes the object later.
+func withdrawS3Object(ctx context.Context, db *sql.DB, fileID int, fileURL string) {
+	if fileURL == "" {
+		return
+	}
+	if err := utils.DeleteFromS3(fileURL); err != nil {
+		log.Printf(" Failed to withdraw S3 object of quarantined file %d: %v\n", fileID, err)
+		return
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE files SET file_url = NULL WHERE id = $1", fileID); err != nil {
+		log.Printf(" Failed to clear file_url of quarantined file %d: %v\n", fileID, err)
+	}
+}
+
+// listHeldFiles returns files held by scanning with the given status (both
+// pending_scan and quarantined when empty), most recent first
+func listHeldFiles(ctx context.Context, db *sql.DB, status string, limit int) ([]HeldFile, error) {
+	statuses := []string{statusPendingScan, statusQuarantined}
+	if status != "" {
+		statuses = []string{status}
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, filename, COALESCE(owner_id, ''), COALESCE(size, 0), status, COALESCE(scan_signature, ''),
+		       COALESCE(scan_error, ''), COALESCE(scanned_by, ''), scanned_at, uploaded_at
+		FROM files WHERE status = ANY($1)
+		ORDER BY COALESCE(scanned_at, uploaded_at) DESC, id DESC LIMIT $2`, statuses, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []HeldFile{}
+	for rows.Next() {
+		var f HeldFile
+		err := rows.Scan(&f.FileID, &f.Filename, &f.Owner, &f.Size, &f.Status, &f.Signature,
+			&f.Error, &f.ScannedBy, &f.ScannedAt, &f.UploadedAt)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
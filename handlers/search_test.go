@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// suggestLatencyTarget is the latency /search/suggest is meant to stay under
+const suggestLatencyTarget = 50 * time.Millisecond
+
+// suggestTestDB connects to TEST_DATABASE_URL and seeds a files table in a
+// throwaway schema: 200k rows over 500 owners, indexed like init.sql. Tests
+// using it are skipped when TEST_DATABASE_URL is unset.
+func suggestTestDB(tb testing.TB) (*sql.Conn, func()) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx) // search_path is per connection
+	if err != nil {
+		tb.Fatal(err)
+	}
+	schema := fmt.Sprintf("suggest_test_%d", time.Now().UnixNano())
+	for _, stmt := range []string{
+		"CREATE SCHEMA " + schema,
+		"SET search_path TO " + schema,
+		`CREATE TABLE files (
+			id SERIAL PRIMARY KEY,
+			filename TEXT NOT NULL,
+			owner_id VARCHAR(255),
+			status VARCHAR(20) NOT NULL DEFAULT 'active',
+			name_key TEXT GENERATED ALWAYS AS (lower(regexp_replace(filename, '^\d+_', ''))) STORED
+		)`,
+		"CREATE INDEX idx_files_owner_name_key ON files (owner_id, name_key text_pattern_ops)",
+		`INSERT INTO files (filename, owner_id)
+			SELECT (1700000000 + g) || '_' || (ARRAY['report', 'gantt_chart', 'invoice', 'photo', 'notes'])[g % 5 + 1]
+			       || '_' || (g % 997) || '.pdf',
+			       'user' || (g % 500) || '@example.com'
+			FROM generate_series(1, 200000) g`,
+		"ANALYZE files",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			tb.Fatalf("seed: %v", err)
+		}
+	}
+	return conn, func() {
+		conn.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		conn.Close()
+		db.Close()
+	}
+}
+
+type explainNode struct {
+	NodeType     string        `json:"Node Type"`
+	RelationName string        `json:"Relation Name"`
+	Plans        []explainNode `json:"Plans"`
+}
+
+func (n explainNode) seqScans(table string) int {
+	count := 0
+	if n.NodeType == "Seq Scan" && n.RelationName == table {
+		count++
+	}
+	for _, child := range n.Plans {
+		count += child.seqScans(table)
+	}
+	return count
+}
+
+// TestSuggestQueryPlan checks that suggestQuery is served by
+// idx_files_owner_name_key under the generic plan the prepared statement ends
+// up with, and runs within suggestLatencyTarget
+func TestSuggestQueryPlan(t *testing.T) {
+	conn, done := suggestTestDB(t)
+	defer done()
+	ctx := context.Background()
+
+	for _, stmt := range []string{
+		"SET plan_cache_mode = force_generic_plan",
+		"PREPARE suggest AS " + suggestQuery,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var raw []byte
+	err := conn.QueryRowContext(ctx,
+		`EXPLAIN (ANALYZE, FORMAT JSON) EXECUTE suggest('user42@example.com', 'gan', 10)`).Scan(&raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plans []struct {
+		Plan          explainNode `json:"Plan"`
+		ExecutionTime float64     `json:"Execution Time"` // milliseconds
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil || len(plans) != 1 {
+		t.Fatalf("unexpected EXPLAIN output %s: %v", raw, err)
+	}
+	if n := plans[0].Plan.seqScans("files"); n > 0 {
+		t.Errorf("suggest query scans files sequentially:\n%s", raw)
+	}
+	took := time.Duration(plans[0].ExecutionTime * float64(time.Millisecond))
+	if took > suggestLatencyTarget {
+		t.Errorf("suggest query took %v, target is %v", took, suggestLatencyTarget)
+	}
+}
+
+// BenchmarkSuggestQuery measures suggestQuery for varying owners; run with
+// TEST_DATABASE_URL=... go test -run '^$' -bench Suggest ./handlers
+func BenchmarkSuggestQuery(b *testing.B) {
+	conn, done := suggestTestDB(b)
+	defer done()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows, err := conn.QueryContext(ctx, suggestQuery, fmt.Sprintf("user%d@example.com", i%500), "rep", defaultSuggestions)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	appConfig "github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/notify"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment line, so proxies
+// do not time the connection out and clients notice a dead one
+const sseHeartbeat = 15 * time.Second
+
+// sseBuffer is how many live frames may wait for the stream before the client
+// is considered too slow and disconnected. Replays wait for room instead.
+const sseBuffer = 256
+
+// sseRetry is the reconnect delay suggested to EventSource clients, in milliseconds
+const sseRetry = 5000
+
+var errSlowStream = errors.New("event stream is not keeping up")
+
+type sseFrame struct {
+	id      string
+	message []byte
+}
+
+// EventsHandler streams notifications as Server-Sent Events, for clients whose
+// network breaks WebSocket upgrades. It carries the same notify.Event envelopes
+// as /ws and authenticates the same way (Authorization header or ?token=).
+// Reconnecting clients resume through the Last-Event-ID header, which
+// EventSource sends automatically, or ?last_event_id=. Connections count towards
+// the same per-user limit as WebSockets.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	token := streamToken(r)
+	if token == "" {
+		http.Error(w, " Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, err := appConfig.ValidateJWT(token)
+	if err != nil {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The session only queues frames; this goroutine owns the ResponseWriter
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	frames := make(chan sseFrame, sseBuffer)
+	session := notify.NewSession(userID, func(eventID string, message []byte) error {
+		select {
+		case frames <- sseFrame{id: eventID, message: message}:
+			return nil
+		default:
+			return errSlowStream
+		}
+	}, func(string) { cancel() })
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	register := notify.Register
+	if lastEventID != "" {
+		register = func(s *notify.Session) error { return notify.RegisterResuming(s, lastEventID) }
+	}
+	if err := register(session); err != nil {
+		rejectSession(w, err)
+		return
+	}
+	defer notify.Unregister(session)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	flusher.Flush()
+
+	fmt.Println(" Event stream established for", userID)
+
+	if lastEventID != "" {
+		go func() {
+			err := notify.Replay(ctx, session, func(ctx context.Context, eventID string, message []byte) error {
+				select {
+				case frames <- sseFrame{id: eventID, message: message}:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				fmt.Println(" Event stream replay failed:", err)
+				cancel()
+			}
+		}()
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case frame := <-frames:
+			err = writeSSEFrame(w, frame)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-ctx.Done():
+			fmt.Println(" Event stream closed for", userID)
+			return
+		}
+		if err != nil {
+			fmt.Println(" Event stream error:", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSSEFrame writes one event. Only stored events carry an id line, so the
+// browser's Last-Event-ID always names an inbox entry that can be resumed from.
+func writeSSEFrame(w http.ResponseWriter, frame sseFrame) error {
+	if frame.id != "" && notify.IsStoredEventID(frame.id) {
+		if _, err := fmt.Fprintf(w, "id: %s\n", frame.id); err != nil {
+			return err
+		}
+	}
+	// JSON encoding never contains raw newlines, so one data line suffices
+	_, err := fmt.Fprintf(w, "data: %s\n\n", frame.message)
+	return err
+}
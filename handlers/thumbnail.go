@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/gorilla/mux"
+)
+
+// maxPreviewSource bounds how much of an image is read into memory for thumbnailing
+const maxPreviewSource = 64 << 20
+
+// generateThumbnails renders every utils.ThumbnailSizes derivative for an image or
+// PDF upload, stores each next to the original locally and in S3, and records it in
+// file_derivatives. Files without a previewable format are skipped.
+func generateThumbnails(ctx context.Context, db *sql.DB, fileID int) (int, error) {
+	var filename, filePath, fileURL string
+	err := db.QueryRow("SELECT filename, filepath, COALESCE(file_url, '') FROM files WHERE id = $1", fileID).
+		Scan(&filename, &filePath, &fileURL)
+	if err == sql.ErrNoRows {
+		return 0, nil // deleted before we got to it
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load file %d: %v", fileID, err)
+	}
+
+	src, sourceFormat, err := loadPreviewSource(ctx, filename, filePath, fileURL)
+	if err != nil || src == nil {
+		return 0, err
+	}
+
+	// Derivatives are named after the stored file, not the display name, which
+	// RenameFile changes
+	storedName := filepath.Base(filePath)
+	generated := 0
+	for _, size := range utils.ThumbnailSizes {
+		thumb := utils.ResizeToFit(src, size.Max)
+		var buf bytes.Buffer
+		contentType, ext, err := utils.EncodeThumbnail(&buf, thumb, sourceFormat)
+		if err != nil {
+			return generated, fmt.Errorf("failed to encode %s thumbnail: %v", size.Name, err)
+		}
+
+		name := fmt.Sprintf("%s.thumb-%s%s", storedName, size.Name, ext)
+		thumbPath := filepath.Join(filepath.Dir(filePath), name)
+		if err := os.WriteFile(thumbPath, buf.Bytes(), 0644); err != nil {
+			return generated, fmt.Errorf("failed to save %s thumbnail: %v", size.Name, err)
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		thumbURL, err := uploadToS3(bytes.NewReader(buf.Bytes()), name, hex.EncodeToString(sum[:]))
+		if err != nil {
+			log.Printf(" Keeping %s thumbnail for file %d local only: %v\n", size.Name, fileID, err)
+		}
+
+		_, err = db.Exec(`INSERT INTO file_derivatives (file_id, kind, size, filepath, file_url, content_type, width, height, bytes)
+			VALUES ($1, 'thumbnail', $2, $3, NULLIF($4, ''), $5, $6, $7, $8)
+			ON CONFLICT (file_id, kind, size) DO UPDATE
+			SET filepath = EXCLUDED.filepath, file_url = EXCLUDED.file_url, content_type = EXCLUDED.content_type,
+			    width = EXCLUDED.width, height = EXCLUDED.height, bytes = EXCLUDED.bytes, created_at = NOW()`,
+			fileID, size.Name, thumbPath, thumbURL, contentType, thumb.Bounds().Dx(), thumb.Bounds().Dy(), buf.Len())
+		if err != nil {
+			return generated, fmt.Errorf("failed to record %s thumbnail: %v", size.Name, err)
+		}
+		generated++
+	}
+	return generated, nil
+}
+
+// loadPreviewSource decodes the image a thumbnail is made from: the image itself,
+// or the first embedded page image of a PDF. It returns nil for other formats.
+func loadPreviewSource(ctx context.Context, filename, filePath, fileURL string) (image.Image, string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".gif", ".pdf":
+	default:
+		return nil, "", nil
+	}
+
+	obj, err := utils.OpenStoredFile(ctx, filePath, fileURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer obj.Close()
+
+	if ext == ".pdf" {
+		img, err := utils.ExtractPDFPreview(obj)
+		if err != nil {
+			log.Printf(" No preview for %s: %v\n", filename, err)
+			return nil, "", nil
+		}
+		return img, "jpeg", nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(obj, maxPreviewSource))
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := utils.DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		log.Printf(" No thumbnail for %s: %v\n", filename, err)
+		return nil, "", nil
+	}
+	return img, format, nil
+}
+
+// thumbnailSizeName maps ?size= to a ThumbnailSizes name. It accepts a name
+// ("small") or a pixel count, which picks the smallest size at least that large.
+func thumbnailSizeName(raw string) string {
+	if raw == "" {
+		return "medium"
+	}
+	if px, err := strconv.Atoi(raw); err == nil {
+		for _, size := range utils.ThumbnailSizes {
+			if size.Max >= px {
+				return size.Name
+			}
+		}
+		return utils.ThumbnailSizes[len(utils.ThumbnailSizes)-1].Name
+	}
+	for _, size := range utils.ThumbnailSizes {
+		if size.Name == raw {
+			return size.Name
+		}
+	}
+	return ""
+}
+
+// GetThumbnail serves a generated thumbnail of one of the caller's files
+func GetThumbnail(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		fileID := mux.Vars(r)["file_id"]
+		size := thumbnailSizeName(r.URL.Query().Get("size"))
+		if size == "" {
+			http.Error(w, "Invalid thumbnail size", http.StatusBadRequest)
+			return
+		}
+
+		var thumbPath, thumbURL, contentType string
+		err := db.QueryRow(`SELECT d.filepath, COALESCE(d.file_url, ''), d.content_type
+			FROM file_derivatives d JOIN files f ON f.id = d.file_id
+			WHERE d.file_id = $1 AND d.kind = 'thumbnail' AND d.size = $2 AND f.owner_id = $3 AND f.status = 'active'`,
+			fileID, size, userID).Scan(&thumbPath, &thumbURL, &contentType)
+		if err != nil {
+			http.Error(w, "Thumbnail not available", http.StatusNotFound)
+			return
+		}
+
+		obj, err := utils.OpenStoredFile(r.Context(), thumbPath, thumbURL)
+		if err != nil {
+			log.Println(" Thumbnail open error:", err)
+			http.Error(w, "Thumbnail not available", http.StatusNotFound)
+			return
+		}
+		defer obj.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		if rs, ok := obj.ReadCloser.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, "", obj.ModTime, rs)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		io.Copy(w, obj)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+)
+
+// TestThumbnailsNamedAfterStoredFile checks that derivatives are written next
+// to the stored file and uploaded under its name whatever the file's display
+// name says
+func TestThumbnailsNamedAfterStoredFile(t *testing.T) {
+	env := setupCacheTest(t)
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	storeUpload(t, "1700000000_photo.png", img.String())
+
+	var uploaded []string
+	stored := uploadToS3
+	uploadToS3 = func(file io.Reader, fileName, sha256Hex string) (string, error) {
+		uploaded = append(uploaded, fileName)
+		return "https://bucket.example/" + fileName, nil
+	}
+	t.Cleanup(func() { uploadToS3 = stored })
+
+	env.mock.ExpectQuery(quoted("SELECT filename, filepath")).WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"filename", "filepath", "file_url"}).
+			AddRow("../../escape.png", "uploads/1700000000_photo.png", ""))
+	for range utils.ThumbnailSizes {
+		env.mock.ExpectExec(quoted("INSERT INTO file_derivatives")).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	n, err := generateThumbnails(context.Background(), env.db, 5)
+	if err != nil || n != len(utils.ThumbnailSizes) {
+		t.Fatalf("generateThumbnails = %d, %v", n, err)
+	}
+
+	if len(uploaded) != len(utils.ThumbnailSizes) {
+		t.Fatalf("uploaded %v", uploaded)
+	}
+	for _, name := range uploaded {
+		if !strings.HasPrefix(name, "1700000000_photo.png.thumb-") {
+			t.Errorf("thumbnail uploaded as %q", name)
+		}
+		if _, err := os.Stat(filepath.Join("uploads", name)); err != nil {
+			t.Errorf("thumbnail not stored next to the original: %v", err)
+		}
+	}
+	env.done()
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SOMAK939/file-sharing-platform/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// WebhookRequest registers an endpoint for file lifecycle events
+type WebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"` // defaults to ["*"]
+	Description string   `json:"description"`
+}
+
+// dispatchWebhook sends a file event to the owner's webhooks without holding up the response
+func dispatchWebhook(owner, eventType string, data webhooks.FileData) {
+	go func() {
+		if err := webhooks.Dispatch(context.Background(), owner, eventType, data); err != nil {
+			log.Printf(" Webhook dispatch error for %s: %v\n", eventType, err)
+		}
+	}()
+}
+
+// CreateWebhook registers a webhook for the caller. The response includes the
+// signing secret, which is not shown again.
+func CreateWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		if err := webhooks.ValidateURL(r.Context(), req.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events, err := webhooks.ValidateEvents(req.Events)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hook, err := webhooks.Create(r.Context(), db, userID, req.URL, req.Description, events)
+		if err != nil {
+			log.Println(" Webhook create error:", err)
+			http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+	}
+}
+
+// ListWebhooks returns the caller's webhooks
+func ListWebhooks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+
+		hooks, err := webhooks.List(r.Context(), db, userID)
+		if err != nil {
+			log.Println(" Webhook list error:", err)
+			http.Error(w, "Failed to load webhooks", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": hooks})
+	}
+}
+
+// DeleteWebhook removes one of the caller's webhooks along with its delivery log
+func DeleteWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+		webhookID, err := strconv.Atoi(mux.Vars(r)["webhook_id"])
+		if err != nil {
+			http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+			return
+		}
+
+		err = webhooks.Delete(r.Context(), db, userID, webhookID)
+		if err == webhooks.ErrNotFound {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println(" Webhook delete error:", err)
+			http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListWebhookDeliveries returns the delivery log of one of the caller's webhooks,
+// newest first, with the response code of the last attempt. Query: limit (default 50, max 200).
+func ListWebhookDeliveries(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+		webhookID, err := strconv.Atoi(mux.Vars(r)["webhook_id"])
+		if err != nil {
+			http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 200)
+		}
+
+		deliveries, err := webhooks.Deliveries(r.Context(), db, userID, webhookID, limit)
+		if err == webhooks.ErrNotFound {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println(" Webhook delivery log error:", err)
+			http.Error(w, "Failed to load deliveries", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"webhook_id": webhookID, "deliveries": deliveries})
+	}
+}
+
+// RedeliverWebhook queues an earlier delivery to be sent again with the same event id
+func RedeliverWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+		vars := mux.Vars(r)
+		webhookID, err1 := strconv.Atoi(vars["webhook_id"])
+		deliveryID, err2 := strconv.Atoi(vars["delivery_id"])
+		if err1 != nil || err2 != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+
+		delivery, err := webhooks.Redeliver(r.Context(), db, userID, webhookID, deliveryID)
+		if err == webhooks.ErrNotFound {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println(" Webhook redeliver error:", err)
+			http.Error(w, "Failed to redeliver", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
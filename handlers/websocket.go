@@ -1,51 +1,302 @@
 package handlers
 
 import (
-    "fmt"
-    "net/http"
-    "sync"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
-    "github.com/gorilla/websocket"
+	appConfig "github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/notify"
+	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
-    CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: checkOrigin,
 }
 
-var clients = make(map[*websocket.Conn]bool)
-var mu sync.Mutex
+// authTimeout is how long a connection without a ?token= has to send its auth message
+const authTimeout = 10 * time.Second
 
+// checkOrigin accepts same-origin requests, non-browser clients that send no
+// Origin, and the origins listed in WS_ALLOWED_ORIGINS ("*" allows any)
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range appConfig.GetEnvList("WS_ALLOWED_ORIGINS") {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Connection timing and limits for notification WebSockets
+const (
+	wsWriteWait      = 10 * time.Second    // time allowed to write one frame
+	wsPongWait       = 60 * time.Second    // a client silent for longer is considered gone
+	wsPingPeriod     = wsPongWait * 9 / 10 // must be shorter than wsPongWait
+	wsMaxMessageSize = 64 << 10            // client messages are small JSON requests
+)
+
+var errSlowConsumer = errors.New("send buffer full")
+
+// wsSendBuffer is how many live messages may queue for one connection before
+// the client is treated as a slow consumer and disconnected. Replays wait for room.
+func wsSendBuffer() int {
+	return int(appConfig.GetEnvInt64("WS_SEND_BUFFER", 256))
+}
+
+// wsClient owns the writes to one connection. Sessions queue messages on send
+// and never block on the network; writePump is the only goroutine writing frames.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+
+	closeOnce   sync.Once
+	done        chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer()), done: make(chan struct{})}
+}
+
+// enqueue queues a message without blocking
+func (c *wsClient) enqueue(_ string, message []byte) error {
+	select {
+	case <-c.done:
+		return websocket.ErrCloseSent
+	default:
+	}
+	select {
+	case c.send <- message:
+		return nil
+	default:
+		return errSlowConsumer
+	}
+}
+
+// enqueueWait queues a message, waiting for room rather than failing; replays
+// use it so a long catch-up goes at the client's pace
+func (c *wsClient) enqueueWait(ctx context.Context, _ string, message []byte) error {
+	select {
+	case c.send <- message:
+		return nil
+	case <-c.done:
+		return websocket.ErrCloseSent
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown asks writePump to send a close frame with the given code and stop
+func (c *wsClient) shutdown(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.done)
+	})
+}
+
+// closeSession maps the reason a notify.Session is closed to a close frame
+func (c *wsClient) closeSession(reason string) {
+	switch reason {
+	case notify.CloseShutdown:
+		c.shutdown(websocket.CloseGoingAway, reason)
+	case notify.CloseSlowConsumer:
+		c.shutdown(websocket.ClosePolicyViolation, reason)
+	default:
+		c.shutdown(websocket.CloseNormalClosure, reason)
+	}
+}
+
+// writePump sends queued messages and keepalive pings until the client is shut
+// down or a write fails, then sends the close frame and closes the connection,
+// which also ends the read loop
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		c.shutdown(websocket.CloseAbnormalClosure, "") // release anyone waiting to enqueue
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				fmt.Println(" WebSocket write failed:", err)
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				fmt.Println(" WebSocket ping failed:", err)
+				return
+			}
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(c.closeCode, c.closeReason),
+				time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+// WebSocketHandler opens a notification channel for an authenticated user. Events are
+// sent as notify.Event JSON envelopes; clients may send notify.ClientMessage. The token
+// comes from ?token=, the Authorization header, or a first message of the form
+// {"type":"auth","token":"..."} sent within authTimeout of connecting. Passing
+// ?last_event_id= replays the inbox events published after that id, or the unread
+// ones if it names no stored event.
+//
+// The server pings every wsPingPeriod and drops clients that do not answer within
+// wsPongWait. Users over NOTIFY_MAX_CONNECTIONS_PER_USER are refused with 429.
 func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	var userID string
+	if token := streamToken(r); token != "" {
+		id, err := appConfig.ValidateJWT(token)
+		if err != nil {
+			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+			return
+		}
+		userID = id
+		if !checkSessionLimit(w, userID) {
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		// Upgrade has already written the error response
 		fmt.Println(" WebSocket upgrade failed:", err)
-		http.Error(w, "Failed to upgrade WebSocket", http.StatusInternalServerError)
 		return
 	}
 	defer conn.Close()
+	conn.SetReadLimit(wsMaxMessageSize)
+
+	if userID == "" {
+		userID, err = authenticateFirstMessage(conn)
+		if err != nil {
+			fmt.Println(" WebSocket authentication failed:", err)
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication required"),
+				time.Now().Add(time.Second))
+			return
+		}
+	}
+
+	// A reconnecting client passes the last event it saw to receive what it missed
+	client := newWSClient(conn)
+	session := notify.NewSession(userID, client.enqueue, client.closeSession)
+	lastEventID := r.URL.Query().Get("last_event_id")
+	register := notify.Register
+	if lastEventID != "" {
+		register = func(s *notify.Session) error { return notify.RegisterResuming(s, lastEventID) }
+	}
+	if err := register(session); err != nil {
+		// Authenticated after the upgrade, so the limit can only be reported in the close frame
+		fmt.Println(" WebSocket rejected for", userID+":", err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()),
+			time.Now().Add(time.Second))
+		return
+	}
+	defer notify.Unregister(session)
+
+	writerDone := make(chan struct{})
+	go func() {
+		client.writePump()
+		close(writerDone)
+	}()
+	// Let writePump finish its close frame before the deferred conn.Close
+	defer func() {
+		client.shutdown(websocket.CloseNormalClosure, "")
+		<-writerDone
+	}()
+
+	fmt.Println(" WebSocket connection established for", userID)
 
-	fmt.Println(" WebSocket connection established")
+	// A failed catch-up still leaves the session live; the client can page
+	// through /notifications for what it missed
+	if lastEventID != "" {
+		if err := notify.Replay(r.Context(), session, client.enqueueWait); err != nil {
+			fmt.Println(" WebSocket replay failed for", userID+":", err)
+		}
+	}
 
+	// Any frame from the client, pongs included, proves it is still there
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	// Client messages manage topic subscriptions and acknowledge events
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			fmt.Println(" WebSocket error:", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				fmt.Println(" WebSocket error:", err)
+			}
 			break
 		}
-		fmt.Printf(" Received message: %s\n", msg)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		session.HandleMessage(msg)
+	}
+}
+
+// checkSessionLimit refuses the request when the user cannot open another
+// notification connection
+func checkSessionLimit(w http.ResponseWriter, userID string) bool {
+	if err := notify.CanRegister(userID); err != nil {
+		rejectSession(w, err)
+		return false
+	}
+	return true
+}
+
+// rejectSession answers a refused notify.Register with 429, or 503 while shutting down
+func rejectSession(w http.ResponseWriter, err error) {
+	if err == notify.ErrShuttingDown {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, fmt.Sprintf("Too many notification connections (limit %d)", notify.MaxSessionsPerUser()),
+		http.StatusTooManyRequests)
+}
+
+// streamToken returns the token of a notification stream request. Browsers cannot
+// set headers on WebSocket or EventSource connections, so ?token= is accepted too.
+func streamToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
 	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 }
 
-func NotifyUploadComplete(filename, userID string) {
-    mu.Lock()
-    defer mu.Unlock()
+// authenticateFirstMessage waits for an auth message and returns the user it identifies
+func authenticateFirstMessage(conn *websocket.Conn) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
 
-    message := fmt.Sprintf("File %s has been uploaded successfully", filename)
-    for client := range clients {
-        err := client.WriteMessage(websocket.TextMessage, []byte(message))
-        if err != nil {
-            client.Close()
-            delete(clients, client)
-        }
-    }
+	var msg struct {
+		Type  string `json:"type"`
+		Token string `json:"token"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		return "", err
+	}
+	if msg.Type != "auth" || msg.Token == "" {
+		return "", fmt.Errorf("expected auth message, got %q", msg.Type)
+	}
+	return appConfig.ValidateJWT(strings.TrimPrefix(msg.Token, "Bearer "))
 }
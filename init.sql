@@ -15,3 +15,210 @@ CREATE TABLE IF NOT EXISTS files (
     size BIGINT NOT NULL,
     uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+
+-- Columns written by the upload handler
+ALTER TABLE files ADD COLUMN IF NOT EXISTS file_url TEXT;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS owner_id TEXT;
+
+-- Teams share a storage quota across their members
+CREATE TABLE IF NOT EXISTS teams (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    quota_bytes BIGINT, -- NULL uses TEAM_QUOTA_BYTES
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS team_id INTEGER REFERENCES teams(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT; -- NULL uses USER_QUOTA_BYTES
+
+-- Bytes stored per 'user:<email>' and 'team:<id>', updated in the same transaction as files
+CREATE TABLE IF NOT EXISTS storage_usage (
+    subject VARCHAR(255) PRIMARY KEY,
+    bytes_used BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Backfill usage for files uploaded before quotas were tracked
+INSERT INTO storage_usage (subject, bytes_used)
+SELECT 'user:' || owner_id, SUM(COALESCE(size, 0)) FROM files WHERE owner_id IS NOT NULL GROUP BY owner_id
+ON CONFLICT (subject) DO NOTHING;
+
+INSERT INTO storage_usage (subject, bytes_used)
+SELECT 'team:' || u.team_id, SUM(COALESCE(f.size, 0))
+FROM files f JOIN users u ON u.email = f.owner_id
+WHERE u.team_id IS NOT NULL GROUP BY u.team_id
+ON CONFLICT (subject) DO NOTHING;
+
+-- Optional folder path ("reports/2025") used to group files for archive downloads
+ALTER TABLE files ADD COLUMN IF NOT EXISTS folder TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_files_owner_folder ON files (owner_id, folder);
+
+-- Thumbnails and previews generated after upload, stored next to the original
+CREATE TABLE IF NOT EXISTS file_derivatives (
+    id SERIAL PRIMARY KEY,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL, -- 'thumbnail'
+    size VARCHAR(16) NOT NULL, -- 'small', 'medium', 'large'
+    filepath TEXT NOT NULL,
+    file_url TEXT,
+    content_type VARCHAR(100) NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    bytes BIGINT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (file_id, kind, size)
+);
+
+-- Set once the owner has been warned that the file is about to expire
+ALTER TABLE files ADD COLUMN IF NOT EXISTS expiry_notified_at TIMESTAMP;
+
+-- Outbound webhooks: endpoints users register for file lifecycle events
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    owner_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL, -- HMAC-SHA256 signing key, shown to the owner once
+    events TEXT[] NOT NULL DEFAULT '{*}', -- event types to send, '*' for all
+    description TEXT NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhooks_owner ON webhooks (owner_id);
+
+-- One row per event sent to a webhook, updated after every attempt
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL, -- stable across redeliveries so receivers can dedupe
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL, -- the exact request body
+    status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending, retrying, succeeded, failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER,
+    response_body TEXT,
+    error TEXT,
+    redelivery_of INTEGER REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id DESC);
+
+-- Highest fencing token each distributed lock holder has written with; writes
+-- carrying an older token come from a holder whose lease expired and are rejected
+CREATE TABLE IF NOT EXISTS lock_fences (
+    name TEXT PRIMARY KEY,
+    fence BIGINT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 'admin' users can reach the /admin endpoints
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user';
+
+-- Lifecycle state of a file. Cleanup marks expired rows 'deleting' before it
+-- removes their storage, so a crashed or failed run is picked up again next time.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active';
+ALTER TABLE files ADD COLUMN IF NOT EXISTS delete_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS delete_error TEXT;
+CREATE INDEX IF NOT EXISTS idx_files_status_uploaded ON files (status, uploaded_at);
+
+-- One row per cleanup pass
+CREATE TABLE IF NOT EXISTS cleanup_runs (
+    id SERIAL PRIMARY KEY,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    holder TEXT,
+    fence BIGINT,
+    scanned INTEGER NOT NULL DEFAULT 0,
+    deleted INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    bytes_freed BIGINT NOT NULL DEFAULT 0,
+    error TEXT
+);
+
+-- SHA-256 (hex) of the uploaded bytes and the result of the latest scrub
+ALTER TABLE files ADD COLUMN IF NOT EXISTS sha256 CHAR(64);
+ALTER TABLE files ADD COLUMN IF NOT EXISTS integrity_status VARCHAR(16) NOT NULL DEFAULT 'unverified'; -- unverified, ok, corrupt, missing
+ALTER TABLE files ADD COLUMN IF NOT EXISTS integrity_error TEXT;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_files_verified_at ON files (verified_at NULLS FIRST);
+
+-- Full-text search. Filenames and tags use the 'simple' configuration so names
+-- and codes match literally; descriptions and extracted document text are stemmed.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE files ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN IF NOT EXISTS content_text TEXT; -- extracted while processing, NULL if not extractable
+ALTER TABLE files ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION files_search_vector(filename TEXT, tags TEXT[], description TEXT, content TEXT)
+RETURNS tsvector LANGUAGE sql IMMUTABLE AS $$
+    SELECT setweight(to_tsvector('simple', regexp_replace(regexp_replace(COALESCE(filename, ''), '^\d+_', ''), '[._-]+', ' ', 'g')), 'A')
+        || setweight(to_tsvector('simple', array_to_string(COALESCE(tags, '{}'), ' ')), 'A')
+        || setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+        || setweight(to_tsvector('english', COALESCE(content, '')), 'C')
+$$;
+
+CREATE OR REPLACE FUNCTION files_search_vector_update() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.search_vector := files_search_vector(NEW.filename, NEW.tags, NEW.description, NEW.content_text);
+    RETURN NEW;
+END
+$$;
+
+DROP TRIGGER IF EXISTS files_search_vector_trigger ON files;
+CREATE TRIGGER files_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF filename, tags, description, content_text ON files
+    FOR EACH ROW EXECUTE FUNCTION files_search_vector_update();
+
+UPDATE files SET search_vector = files_search_vector(filename, tags, description, content_text)
+    WHERE search_vector IS NULL;
+CREATE INDEX IF NOT EXISTS idx_files_search_vector ON files USING GIN (search_vector);
+
+-- Listing filters: media type recorded at upload and when a share link was first handed out
+ALTER TABLE files ADD COLUMN IF NOT EXISTS mime_type VARCHAR(255);
+ALTER TABLE files ADD COLUMN IF NOT EXISTS shared_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_files_owner_uploaded ON files (owner_id, uploaded_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_files_tags ON files USING GIN (tags);
+
+-- Typo-tolerant name search. name_key is the name as uploaded (without the
+-- "<unix>_" storage prefix), lowercased; trigrams match misspellings and the
+-- pattern index serves prefix autocomplete per owner.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS name_key TEXT GENERATED ALWAYS AS (lower(regexp_replace(filename, '^\d+_', ''))) STORED;
+CREATE INDEX IF NOT EXISTS idx_files_name_key_trgm ON files USING GIN (name_key gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_files_owner_name_key ON files (owner_id, name_key text_pattern_ops);
+
+-- Custom key/value metadata on files, filterable with ?meta.<key>=<value>
+ALTER TABLE files ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS idx_files_metadata ON files USING GIN (metadata jsonb_path_ops);
+
+-- Upload content policies. The row for the uploader's team applies first, then
+-- the one for their role, then 'default'; with none, the UPLOAD_* settings do.
+CREATE TABLE IF NOT EXISTS upload_policies (
+    subject VARCHAR(255) PRIMARY KEY, -- 'team:<id>', 'role:<role>' or 'default'
+    allow_types TEXT[] NOT NULL DEFAULT '{}',
+    deny_types TEXT[] NOT NULL DEFAULT '{}',
+    allow_extensions TEXT[] NOT NULL DEFAULT '{}',
+    deny_extensions TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Malware scanning. With a scanner configured, uploads start as 'pending_scan'
+-- and cannot be downloaded until found clean ('active'); infected files become
+-- 'quarantined'. scan_error holds why the last attempt reached no verdict.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scanned_by VARCHAR(32);
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_signature TEXT;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_error TEXT;
+
+-- Team quota charged for each file ('team:<id>', '' for none), so deleting it
+-- credits that team even after the owner moves. Older rows take the owner's
+-- current team, as the usage backfill above did.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS charged_team VARCHAR(255);
+UPDATE files f SET charged_team = COALESCE((SELECT 'team:' || u.team_id FROM users u WHERE u.email = f.owner_id), '')
+    WHERE charged_team IS NULL;
+
+-- When a scan job was last queued for a 'pending_scan' file, NULL meaning at
+-- upload. Files still waiting SCAN_RETRY_AFTER later get a new job.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_queued_at TIMESTAMP;
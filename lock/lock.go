@@ -0,0 +1,224 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys for a named lock
+const (
+	keyPrefix   = "lock:"       // hash with the current holder; expires with the lease
+	fencePrefix = "lock:fence:" // counter incremented on every acquisition, never expires
+)
+
+// ErrNotHeld is returned when the lock is held by someone else, or the lease was lost
+var ErrNotHeld = errors.New("lock not held")
+
+// ErrStaleFence is returned by CheckFence when a newer holder has already written
+var ErrStaleFence = errors.New("fencing token is stale")
+
+// acquireScript takes the lock if it is free and returns the new fencing token, or 0
+var acquireScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local fence = redis.call('INCR', KEYS[2])
+redis.call('HSET', KEYS[1], 'token', ARGV[1], 'holder', ARGV[2], 'fence', fence,
+	'acquired_at', ARGV[3], 'renewed_at', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return fence
+`)
+
+// renewScript extends the lease if the caller still holds it
+var renewScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'renewed_at', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// releaseScript deletes the lock if the caller still holds it
+var releaseScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// Lease is a held lock. Fence increases with every acquisition of the same lock,
+// so storage can reject writes from a holder whose lease expired without it noticing.
+type Lease struct {
+	Name  string
+	Fence int64
+
+	rdb   *redis.Client
+	token string
+	ttl   time.Duration
+}
+
+// State describes who holds a lock, for the admin endpoint
+type State struct {
+	Name       string     `json:"name"`
+	Held       bool       `json:"held"`
+	Holder     string     `json:"holder,omitempty"`
+	Fence      int64      `json:"fence"` // last token issued, even when the lock is free
+	AcquiredAt *time.Time `json:"acquired_at,omitempty"`
+	RenewedAt  *time.Time `json:"renewed_at,omitempty"`
+	ExpiresIn  string     `json:"expires_in,omitempty"`
+}
+
+// Holder identifies this process in lock state
+var Holder = defaultHolder()
+
+func defaultHolder() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// Acquire takes the named lock for ttl. It returns ErrNotHeld if another process holds it.
+func Acquire(ctx context.Context, rdb *redis.Client, name string, ttl time.Duration) (*Lease, error) {
+	token := newToken()
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	fence, err := acquireScript.Run(ctx, rdb, []string{keyPrefix + name, fencePrefix + name},
+		token, Holder, now, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock %s: %v", name, err)
+	}
+	if fence == 0 {
+		return nil, ErrNotHeld
+	}
+	return &Lease{Name: name, Fence: fence, rdb: rdb, token: token, ttl: ttl}, nil
+}
+
+// Renew extends the lease by its ttl. It returns ErrNotHeld if the lease was lost.
+func (l *Lease) Renew(ctx context.Context) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	ok, err := renewScript.Run(ctx, l.rdb, []string{keyPrefix + l.Name}, l.token, now, l.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("renew lock %s: %v", l.Name, err)
+	}
+	if ok == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Release gives the lock up if it is still held by this lease
+func (l *Lease) Release(ctx context.Context) error {
+	_, err := releaseScript.Run(ctx, l.rdb, []string{keyPrefix + l.Name}, l.token).Result()
+	return err
+}
+
+// Run acquires the named lock and calls fn while renewing the lease every ttl/3.
+// fn's context is cancelled if a renewal fails, so it should stop promptly. Run
+// reports false without calling fn when another process holds the lock.
+func Run(ctx context.Context, rdb *redis.Client, name string, ttl time.Duration, fn func(ctx context.Context, fence int64) error) (bool, error) {
+	lease, err := Acquire(ctx, rdb, name, ttl)
+	if err == ErrNotHeld {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				if err := lease.Renew(runCtx); err != nil {
+					if runCtx.Err() == nil {
+						log.Printf(" Lost lock %s (fence %d): %v\n", name, lease.Fence, err)
+						cancel()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	err = fn(runCtx, lease.Fence)
+	cancel()
+	<-renewed
+
+	releaseCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	if relErr := lease.Release(releaseCtx); relErr != nil {
+		log.Printf(" Failed to release lock %s: %v\n", name, relErr)
+	}
+	return true, err
+}
+
+// Inspect reports the current state of the named lock
+func Inspect(ctx context.Context, rdb *redis.Client, name string) (*State, error) {
+	state := &State{Name: name}
+	fence, err := rdb.Get(ctx, fencePrefix+name).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	state.Fence = fence
+
+	fields, err := rdb.HGetAll(ctx, keyPrefix+name).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return state, nil
+	}
+	state.Held = true
+	state.Holder = fields["holder"]
+	state.Fence, _ = strconv.ParseInt(fields["fence"], 10, 64)
+	if t, err := time.Parse(time.RFC3339Nano, fields["acquired_at"]); err == nil {
+		state.AcquiredAt = &t
+	}
+	if t, err := time.Parse(time.RFC3339Nano, fields["renewed_at"]); err == nil {
+		state.RenewedAt = &t
+	}
+	if ttl, err := rdb.PTTL(ctx, keyPrefix+name).Result(); err == nil && ttl > 0 {
+		state.ExpiresIn = ttl.Round(time.Millisecond).String()
+	}
+	return state, nil
+}
+
+// CheckFence records fence as the newest token seen for the lock in the database
+// and fails with ErrStaleFence if a newer one was already recorded. Call it inside
+// the transaction that performs the protected write so a holder that lost its
+// lease cannot overwrite work done by its successor.
+func CheckFence(ctx context.Context, tx *sql.Tx, name string, fence int64) error {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO lock_fences (name, fence, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET fence = EXCLUDED.fence, updated_at = NOW()
+		WHERE lock_fences.fence <= EXCLUDED.fence`, name, fence)
+	if err != nil {
+		return fmt.Errorf("check fence for %s: %v", name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStaleFence
+	}
+	return nil
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
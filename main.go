@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
      
 	"github.com/SOMAK939/file-sharing-platform/config"
 	"github.com/SOMAK939/file-sharing-platform/handlers"
+	"github.com/SOMAK939/file-sharing-platform/notify"
+	"github.com/SOMAK939/file-sharing-platform/progress"
+	"github.com/SOMAK939/file-sharing-platform/queue"
+	"github.com/SOMAK939/file-sharing-platform/scan"
+	"github.com/SOMAK939/file-sharing-platform/webhooks"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -44,32 +53,107 @@ func main() {
 
 		
 
-	// Upload queue for concurrency
-	uploadQueue := make(chan string, 10)
-	go handlers.ProcessUploads(uploadQueue)
+	// Cancelled on SIGINT/SIGTERM to stop background loops and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Fan notifications out to every instance through Redis Pub/Sub
+	notify.Init(config.RDB)
+	go notify.Listen(ctx)
+	progress.Init(config.RDB)
+
+	// Uploads are held until scanned when SCANNER is set
+	scanner := scan.FromEnv()
+
+	// Durable job queue for post-upload processing
+	jobQueue := queue.New(config.RDB, queue.Options{
+		MaxAttempts:       int(config.GetEnvInt64("JOB_MAX_ATTEMPTS", 5)),
+		VisibilityTimeout: config.GetEnvDuration("JOB_VISIBILITY_TIMEOUT", 5*time.Minute),
+		Concurrency:       int(config.GetEnvInt64("JOB_WORKERS", 2)),
+	})
+	jobQueue.Register(queue.TypeProcessUpload, handlers.ProcessUploadJob(db, config.RDB, scanner))
+	jobQueue.OnDead(queue.TypeProcessUpload, handlers.ProcessUploadFailed)
+
+	// Outbound webhooks are delivered and retried through the same queue
+	webhooks.Init(db, jobQueue)
+	jobQueue.Register(queue.TypeDeliverWebhook, webhooks.DeliverJob(db, webhooks.NewSender(nil)))
+	jobQueue.OnDead(queue.TypeDeliverWebhook, webhooks.DeliveryFailed(db))
+	go jobQueue.Run(ctx)
 
 	// Set up router
 	router := mux.NewRouter()
 	router.HandleFunc("/register", handlers.RegisterUser(db)).Methods("POST")
 	router.HandleFunc("/login", handlers.LoginUser(db)).Methods("POST")
-	router.HandleFunc("/upload", handlers.UploadFile(db, uploadQueue)).Methods("POST")
+	router.HandleFunc("/upload", handlers.UploadFile(db, config.RDB, jobQueue, scanner)).Methods("POST")
 
-	router.HandleFunc("/download/{filename}", handlers.DownloadFile).Methods("GET")
+	router.HandleFunc("/uploads/{upload_id}/progress", handlers.GetUploadProgress()).Methods("GET")
+	router.HandleFunc("/download/{filename}", handlers.DownloadFile(db)).Methods("GET", "HEAD")
 	router.HandleFunc("/file/{filename}", handlers.GetFileURL(db)).Methods("GET")
-	router.HandleFunc("/share/{file_id}", handlers.GetFileShareableURL(db)).Methods("GET")
+	router.HandleFunc("/share/{file_id}", handlers.GetFileShareableURL(db, config.RDB)).Methods("GET")
 	router.HandleFunc("/user/files", handlers.GetUserFiles(db, config.RDB)).Methods("GET")
+	router.HandleFunc("/user/usage", handlers.GetUserUsage(db)).Methods("GET")
 	router.HandleFunc("/search", handlers.SearchFiles(db, config.RDB)).Methods("GET")
+	router.HandleFunc("/search/suggest", handlers.SuggestFiles(db, config.RDB)).Methods("GET")
+	router.HandleFunc("/files/archive", handlers.DownloadArchive(db)).Methods("GET", "POST")
 	router.HandleFunc("/files/{file_id}", handlers.GetFileMetadata(db, config.RDB)).Methods("GET")
+	router.HandleFunc("/files/{file_id}", handlers.UpdateFileAnnotations(db, config.RDB)).Methods("PATCH")
+	router.HandleFunc("/files/{file_id}/tags", handlers.AddFileTags(db, config.RDB)).Methods("POST")
+	router.HandleFunc("/files/{file_id}/tags/{tag}", handlers.RemoveFileTag(db, config.RDB)).Methods("DELETE")
+	router.HandleFunc("/tags", handlers.ListTags(db, config.RDB)).Methods("GET")
+	router.HandleFunc("/files/{file_id}/thumbnail", handlers.GetThumbnail(db)).Methods("GET", "HEAD")
 	router.HandleFunc("/files/{file_id}/rename", handlers.RenameFile(db, config.RDB)).Methods("PUT")
+	router.HandleFunc("/files/{file_id}/jobs", handlers.GetFileJobs(db, jobQueue)).Methods("GET")
+	router.HandleFunc("/jobs/{job_id}", handlers.GetJobStatus(jobQueue)).Methods("GET")
+	router.HandleFunc("/notifications", handlers.ListNotifications()).Methods("GET")
+	router.HandleFunc("/notifications/read-all", handlers.MarkAllNotificationsRead()).Methods("POST")
+	router.HandleFunc("/notifications/{event_id}/read", handlers.MarkNotificationRead()).Methods("POST")
+	router.HandleFunc("/webhooks", handlers.CreateWebhook(db)).Methods("POST")
+	router.HandleFunc("/webhooks", handlers.ListWebhooks(db)).Methods("GET")
+	router.HandleFunc("/webhooks/{webhook_id}", handlers.DeleteWebhook(db)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{webhook_id}/deliveries", handlers.ListWebhookDeliveries(db)).Methods("GET")
+	router.HandleFunc("/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver", handlers.RedeliverWebhook(db)).Methods("POST")
+	router.HandleFunc("/admin/locks/cleanup", handlers.GetCleanupLock(db, config.RDB)).Methods("GET")
+	router.HandleFunc("/admin/cleanup/runs", handlers.ListCleanupRuns(db)).Methods("GET")
+	router.HandleFunc("/admin/cleanup/run", handlers.TriggerCleanup(db, config.RDB)).Methods("POST")
+	router.HandleFunc("/admin/cleanup/stuck", handlers.ListStuckDeletions(db)).Methods("GET")
+	router.HandleFunc("/admin/cleanup/stuck/{file_id}/retry", handlers.RetryStuckDeletion(db)).Methods("POST")
+	router.HandleFunc("/admin/reconcile", handlers.Reconcile(db, config.RDB)).Methods("GET", "POST")
+	router.HandleFunc("/admin/integrity", handlers.ListIntegrityIssues(db)).Methods("GET")
+	router.HandleFunc("/admin/integrity/scrub", handlers.TriggerScrub(db, config.RDB)).Methods("POST")
+	router.HandleFunc("/admin/quarantine", handlers.ListQuarantinedFiles(db)).Methods("GET")
+	router.HandleFunc("/admin/upload-policies", handlers.ListUploadPolicies(db)).Methods("GET")
+	router.HandleFunc("/admin/upload-policies/{subject}", handlers.SaveUploadPolicy(db)).Methods("PUT")
+	router.HandleFunc("/admin/upload-policies/{subject}", handlers.DeleteUploadPolicy(db)).Methods("DELETE")
 	router.HandleFunc("/ws", handlers.WebSocketHandler)
+	router.HandleFunc("/events", handlers.EventsHandler).Methods("GET")
 
 
 	// Start background worker for expired file cleanup
-    workers.StartFileCleanupWorker(db)
+    workers.StartFileCleanupWorker(db, config.RDB)
+	workers.StartScrubWorker(db, config.RDB)
+	workers.StartScanSweeper(db, jobQueue)
 	
 
 
 	// Start server
-	fmt.Println(" Server running on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	server := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		fmt.Println(" Server running on port 8080...")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(" Server error:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	fmt.Println(" Shutting down...")
+
+	// Send close frames to notification clients first; hijacked WebSocket
+	// connections are not tracked by server.Shutdown
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.GetEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second))
+	defer cancel()
+	notify.Shutdown(shutdownCtx)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println(" Server shutdown error:", err)
+	}
 }
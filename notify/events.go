@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ProtocolVersion is sent in every envelope as "v"; bump it on breaking changes
+const ProtocolVersion = 1
+
+// Event types delivered to clients. Clients subscribe to these names as topics.
+const (
+	TypeUploadCompleted    = "upload.completed"
+	TypeProcessingFinished = "processing.finished"
+	TypeFileShared         = "file.shared"
+	TypeShareLinkAccessed  = "share_link.accessed"
+	TypeFileExpiringSoon   = "file.expiring_soon"
+	TypeFileDeleted        = "file.deleted"
+	TypeFileQuarantined    = "file.quarantined"
+	TypeUploadProgress     = "upload.progress" // transient, never stored in the inbox
+)
+
+// Control message types exchanged with clients outside the event stream
+const (
+	TypeAck   = "ack"   // server reply to a client request, or client acknowledging an event
+	TypeError = "error" // server reply to a request it could not handle
+)
+
+// EventTypes lists every notification topic a client can subscribe to
+var EventTypes = []string{
+	TypeUploadCompleted,
+	TypeProcessingFinished,
+	TypeFileShared,
+	TypeShareLinkAccessed,
+	TypeFileExpiringSoon,
+	TypeFileDeleted,
+	TypeFileQuarantined,
+	TypeUploadProgress,
+}
+
+// Event is the JSON envelope for every server to client message
+type Event struct {
+	Version   int             `json:"v"`
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// NewEvent wraps payload in a versioned envelope with a fresh id
+func NewEvent(eventType string, payload any) Event {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		raw = []byte("null")
+	}
+	return Event{
+		Version:   ProtocolVersion,
+		Type:      eventType,
+		ID:        newEventID(),
+		Timestamp: time.Now().UTC(),
+		Payload:   raw,
+	}
+}
+
+// FilePayload identifies the file an event is about
+type FilePayload struct {
+	FileID   int    `json:"file_id"`
+	Filename string `json:"filename"`
+}
+
+// UploadCompletedPayload is sent once a file is stored
+type UploadCompletedPayload struct {
+	FilePayload
+	Size  int64  `json:"size"`
+	URL   string `json:"url,omitempty"` // withheld while the file waits for a scan
+	JobID string `json:"job_id,omitempty"`
+}
+
+// ProcessingFinishedPayload is sent when post-upload processing ends
+type ProcessingFinishedPayload struct {
+	FilePayload
+	Status     string `json:"status"` // "succeeded" or "failed"
+	Thumbnails int    `json:"thumbnails"`
+	Error      string `json:"error,omitempty"`
+}
+
+// FileSharedPayload is sent to the owner when a share link for their file is
+// first handed out
+type FileSharedPayload struct {
+	FilePayload
+	SharedBy string `json:"shared_by,omitempty"` // empty for anonymous requests
+}
+
+// ShareLinkAccessedPayload is sent to the owner when a share link is fetched
+type ShareLinkAccessedPayload struct {
+	FilePayload
+	AccessedBy string `json:"accessed_by,omitempty"` // empty for anonymous access
+}
+
+// FileExpiringPayload warns the owner before the cleanup worker removes a file
+type FileExpiringPayload struct {
+	FilePayload
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FileDeletedPayload is sent after a file is removed
+type FileDeletedPayload struct {
+	FilePayload
+	Reason string `json:"reason"` // e.g. "expired"
+}
+
+// FileQuarantinedPayload is sent to the owner when a scan finds malware in their file
+type FileQuarantinedPayload struct {
+	FilePayload
+	Signature string `json:"signature"`
+	Scanner   string `json:"scanner"`
+}
+
+// AckPayload answers a client request
+type AckPayload struct {
+	RequestID string   `json:"request_id,omitempty"`
+	Action    string   `json:"action"`
+	Topics    []string `json:"topics,omitempty"`
+	EventID   string   `json:"event_id,omitempty"`
+}
+
+// ErrorPayload explains why a client request was rejected
+type ErrorPayload struct {
+	RequestID string `json:"request_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+// ClientMessage is what clients send: subscribe/unsubscribe to topics or ack an event
+type ClientMessage struct {
+	Type      string   `json:"type"` // "subscribe", "unsubscribe" or "ack"
+	RequestID string   `json:"request_id,omitempty"`
+	Topics    []string `json:"topics,omitempty"`
+	EventID   string   `json:"event_id,omitempty"`
+}
+
+func newEventID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,320 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+)
+
+// Reasons the server gives when it closes a session
+const (
+	CloseSlowConsumer = "slow consumer" // the client did not keep up with its events
+	CloseShutdown     = "server shutting down"
+)
+
+// ErrTooManySessions is returned by Register when the user already has the maximum
+// number of connections open on this instance
+var ErrTooManySessions = errors.New("too many notification connections")
+
+// ErrShuttingDown is returned by Register once Shutdown has started
+var ErrShuttingDown = errors.New("notification service is shutting down")
+
+// Session is one client connection receiving a user's events. The transport
+// supplies write, which must deliver one message, and close, which ends the
+// connection. write gets the event id alongside the encoded message for
+// transports that frame it separately (SSE); it is empty for control replies.
+// write is called while the session is locked, so it must not block on the
+// client: transports queue the message and fail when their buffer is full.
+type Session struct {
+	UserID string
+
+	write func(eventID string, message []byte) error
+	close func(reason string)
+
+	mu        sync.Mutex
+	topics    map[string]bool // nil means subscribed to everything
+	lastAck   string
+	lastSent  string // newest inbox event id written, to skip duplicates after a replay
+	replaying bool   // registered by RegisterResuming and Replay has not finished
+}
+
+// NewSession creates a session subscribed to every event type
+func NewSession(userID string, write func(eventID string, message []byte) error, close func(reason string)) *Session {
+	return &Session{UserID: userID, write: write, close: close}
+}
+
+// sessions holds the open sessions of each user, keyed by user ID
+var sessions = make(map[string]map[*Session]bool)
+var sessionsMu sync.RWMutex
+var shuttingDown bool
+
+// MaxSessionsPerUser is how many WebSocket and SSE connections one user may hold
+// open on an instance
+func MaxSessionsPerUser() int {
+	return int(config.GetEnvInt64("NOTIFY_MAX_CONNECTIONS_PER_USER", 5))
+}
+
+// CanRegister reports whether Register would currently accept another session
+// for the user, so transports can refuse before upgrading the connection
+func CanRegister(userID string) error {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	return canRegister(userID)
+}
+
+// canRegister checks the limits; callers hold sessionsMu
+func canRegister(userID string) error {
+	if shuttingDown {
+		return ErrShuttingDown
+	}
+	if len(sessions[userID]) >= MaxSessionsPerUser() {
+		return ErrTooManySessions
+	}
+	return nil
+}
+
+// Register starts delivering the user's events to s
+func Register(s *Session) error {
+	return register(s)
+}
+
+// RegisterResuming registers s for a client that already saw lastEventID. Live
+// events are held back until Replay has sent what the client missed, so none
+// arrives twice or ahead of older ones; call Replay next.
+func RegisterResuming(s *Session, lastEventID string) error {
+	s.mu.Lock()
+	s.lastSent, s.replaying = lastEventID, true
+	s.mu.Unlock()
+	return register(s)
+}
+
+func register(s *Session) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if err := canRegister(s.UserID); err != nil {
+		return err
+	}
+	if sessions[s.UserID] == nil {
+		sessions[s.UserID] = make(map[*Session]bool)
+	}
+	sessions[s.UserID][s] = true
+	return nil
+}
+
+// Unregister stops delivering events to s
+func Unregister(s *Session) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(sessions[s.UserID], s)
+	if len(sessions[s.UserID]) == 0 {
+		delete(sessions, s.UserID)
+	}
+}
+
+// deliverLocal writes an encoded event to the sessions of userID connected to this instance
+func deliverLocal(userID string, event Event, message []byte) {
+	sessionsMu.RLock()
+	targets := make([]*Session, 0, len(sessions[userID]))
+	for s := range sessions[userID] {
+		targets = append(targets, s)
+	}
+	sessionsMu.RUnlock()
+
+	for _, s := range targets {
+		if !s.Subscribed(event.Type) {
+			continue
+		}
+		if err := s.sendEvent(event.ID, message); err != nil {
+			log.Printf(" Dropping notification session for %s: %v\n", userID, err)
+			Unregister(s)
+			s.close(CloseSlowConsumer)
+		}
+	}
+}
+
+// Shutdown refuses new sessions, asks every open one to close, and waits until
+// their transports have unregistered them or ctx is done
+func Shutdown(ctx context.Context) {
+	sessionsMu.Lock()
+	shuttingDown = true
+	open := []*Session{}
+	for _, userSessions := range sessions {
+		for s := range userSessions {
+			open = append(open, s)
+		}
+	}
+	sessionsMu.Unlock()
+
+	for _, s := range open {
+		s.close(CloseShutdown)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		sessionsMu.RLock()
+		remaining := len(sessions)
+		sessionsMu.RUnlock()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf(" Shutdown left %d users with open notification sessions\n", remaining)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Subscribed reports whether s wants events of the given type
+func (s *Session) Subscribed(eventType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.topics == nil || s.topics[eventType]
+}
+
+// Send writes one message to the session; writes are serialised per session
+func (s *Session) Send(message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write("", message)
+}
+
+// sendEvent writes an event unless a replay already delivered it. Until the
+// replay of a resuming session finishes, stored events are left for it to read
+// from the inbox and transient ones, which later updates supersede, are dropped.
+func (s *Session) sendEvent(eventID string, message []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaying || (s.lastSent != "" && !idAfter(eventID, s.lastSent)) {
+		return nil
+	}
+	if err := s.write(eventID, message); err != nil {
+		return err
+	}
+	if isStreamID(eventID) {
+		s.lastSent = eventID
+	}
+	return nil
+}
+
+// LastAck returns the id of the last event the client acknowledged
+func (s *Session) LastAck() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastAck
+}
+
+// HandleMessage applies a client message (subscribe, unsubscribe or ack)
+// and replies with an ack or error envelope
+func (s *Session) HandleMessage(raw []byte) {
+	var msg ClientMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		s.reply(NewEvent(TypeError, ErrorPayload{Message: "invalid message: expected JSON"}))
+		return
+	}
+
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		topics, err := validateTopics(msg.Topics)
+		if err != nil {
+			s.reply(NewEvent(TypeError, ErrorPayload{RequestID: msg.RequestID, Message: err.Error()}))
+			return
+		}
+		s.mu.Lock()
+		s.applyTopics(msg.Type == "subscribe", topics)
+		current := s.currentTopics()
+		s.mu.Unlock()
+		s.reply(NewEvent(TypeAck, AckPayload{RequestID: msg.RequestID, Action: msg.Type, Topics: current}))
+
+	case "ack":
+		if msg.EventID == "" {
+			s.reply(NewEvent(TypeError, ErrorPayload{RequestID: msg.RequestID, Message: "event_id is required"}))
+			return
+		}
+		s.mu.Lock()
+		s.lastAck = msg.EventID
+		s.mu.Unlock()
+		if msg.RequestID != "" {
+			s.reply(NewEvent(TypeAck, AckPayload{RequestID: msg.RequestID, Action: "ack", EventID: msg.EventID}))
+		}
+
+	default:
+		s.reply(NewEvent(TypeError, ErrorPayload{RequestID: msg.RequestID, Message: fmt.Sprintf("unknown message type %q", msg.Type)}))
+	}
+}
+
+// applyTopics updates the subscription set; callers hold s.mu
+func (s *Session) applyTopics(subscribe bool, topics []string) {
+	for _, topic := range topics {
+		if topic == "*" {
+			if subscribe {
+				s.topics = nil
+			} else {
+				s.topics = map[string]bool{}
+			}
+			continue
+		}
+		if s.topics == nil {
+			if subscribe {
+				continue // already receiving everything
+			}
+			s.topics = make(map[string]bool, len(EventTypes))
+			for _, t := range EventTypes {
+				s.topics[t] = true
+			}
+		}
+		if subscribe {
+			s.topics[topic] = true
+		} else {
+			delete(s.topics, topic)
+		}
+	}
+}
+
+// currentTopics lists the subscribed topics; callers hold s.mu
+func (s *Session) currentTopics() []string {
+	if s.topics == nil {
+		return []string{"*"}
+	}
+	topics := []string{}
+	for _, t := range EventTypes {
+		if s.topics[t] {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+func (s *Session) reply(event Event) {
+	message, _ := json.Marshal(event)
+	if err := s.Send(message); err != nil {
+		log.Printf(" Failed to reply to notification client %s: %v\n", s.UserID, err)
+	}
+}
+
+func validateTopics(topics []string) ([]string, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("topics is required")
+	}
+	for _, topic := range topics {
+		if topic == "*" {
+			continue
+		}
+		known := false
+		for _, t := range EventTypes {
+			known = known || t == topic
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown topic %q", topic)
+		}
+	}
+	return topics, nil
+}
@@ -0,0 +1,332 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys holding each user's notification inbox
+const (
+	inboxPrefix      = "notify:inbox:"       // capped stream of events; entry ids are event ids
+	readSetPrefix    = "notify:read:"        // ids marked read individually
+	readCursorPrefix = "notify:read_cursor:" // everything up to this id is read
+)
+
+// inboxTTL expires the inbox of users who receive nothing for a long time
+const inboxTTL = 90 * 24 * time.Hour
+
+// InboxItem is a stored event with its read state
+type InboxItem struct {
+	Event
+	Read bool `json:"read"`
+}
+
+// InboxPage is one page of a user's inbox, newest first
+type InboxPage struct {
+	Notifications []InboxItem `json:"notifications"`
+	UnreadCount   int         `json:"unread_count"`
+	NextBefore    string      `json:"next_before,omitempty"`
+}
+
+// inboxSize is the number of events kept per user
+func inboxSize() int64 {
+	return config.GetEnvInt64("NOTIFY_INBOX_SIZE", 500)
+}
+
+// storeEvent appends event to the user's inbox and sets its id to the stream entry id
+func storeEvent(ctx context.Context, userID string, event *Event) error {
+	key := inboxPrefix + userID
+	id, err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: inboxSize(),
+		Approx: true,
+		Values: map[string]interface{}{
+			"v":         event.Version,
+			"type":      event.Type,
+			"timestamp": event.Timestamp.Format(time.RFC3339Nano),
+			"payload":   string(event.Payload),
+		},
+	}).Result()
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	rdb.Expire(ctx, key, inboxTTL)
+	return nil
+}
+
+func eventFromMessage(msg redis.XMessage) Event {
+	str := func(name string) string {
+		value, _ := msg.Values[name].(string)
+		return value
+	}
+	event := Event{ID: msg.ID, Type: str("type"), Payload: json.RawMessage(str("payload"))}
+	event.Version, _ = strconv.Atoi(str("v"))
+	event.Timestamp, _ = time.Parse(time.RFC3339Nano, str("timestamp"))
+	if len(event.Payload) == 0 {
+		event.Payload = json.RawMessage("null")
+	}
+	return event
+}
+
+// replayPage is how many inbox events Replay reads at a time
+const replayPage = 100
+
+// Replay sends a session registered with RegisterResuming every stored event
+// after the id it resumed from, then lets live events through, so none arrives
+// twice or out of order. A session resuming from an id that was never stored,
+// such as a transient event's, gets the unread events instead. Events go
+// through wait, which blocks until the transport has queued the message or ctx
+// ends, so a long catch-up proceeds at the client's pace instead of overflowing
+// its buffer. The inbox is read page by page; the session is only held to
+// confirm nothing is left before going live, never while waiting on the client.
+func Replay(ctx context.Context, s *Session, wait func(ctx context.Context, eventID string, message []byte) error) error {
+	s.mu.Lock()
+	cursor := s.lastSent
+	s.mu.Unlock()
+	// On failure the transport closes the session; until then it gets live events
+	defer s.finishReplay(&cursor)
+
+	// Transient events carry ids that were never stored, and a client may
+	// resume from one; it then gets what the user has not read yet
+	var read map[string]bool
+	if !isStreamID(cursor) {
+		log.Printf(" Resuming notifications for %s from unread events: %q is not a stored event id\n", s.UserID, cursor)
+		var err error
+		if cursor, read, err = unreadStart(ctx, s.UserID); err != nil {
+			return err
+		}
+	}
+	for {
+		events, err := eventsAfter(ctx, s.UserID, cursor, replayPage)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			// Events stored after this check are newer than cursor and are
+			// delivered live once replaying is cleared, which happens under the
+			// same lock the live path takes
+			s.mu.Lock()
+			events, err = eventsAfter(ctx, s.UserID, cursor, 1)
+			if err == nil && len(events) == 0 {
+				s.lastSent, s.replaying = cursor, false
+				s.mu.Unlock()
+				return nil
+			}
+			s.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, event := range events {
+			if s.Subscribed(event.Type) && !read[event.ID] {
+				message, _ := json.Marshal(event)
+				if err := wait(ctx, event.ID, message); err != nil {
+					return err
+				}
+			}
+			cursor = event.ID
+		}
+	}
+}
+
+// unreadStart returns the id to replay a user's unread events after, and the
+// later events they marked read one by one
+func unreadStart(ctx context.Context, userID string) (string, map[string]bool, error) {
+	if rdb == nil {
+		return "0-0", nil, nil
+	}
+	cursor, read, err := readState(ctx, userID)
+	if err != nil || cursor == "" {
+		return "0-0", read, err
+	}
+	return cursor, read, nil
+}
+
+// finishReplay lets live events through after a replay that stopped early
+func (s *Session) finishReplay(cursor *string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaying {
+		s.lastSent, s.replaying = *cursor, false
+	}
+}
+
+// eventsAfter returns up to count stored events after id, oldest first
+func eventsAfter(ctx context.Context, userID, id string, count int64) ([]Event, error) {
+	if rdb == nil {
+		return nil, nil
+	}
+	msgs, err := rdb.XRangeN(ctx, inboxPrefix+userID, "("+id, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, len(msgs))
+	for i, msg := range msgs {
+		events[i] = eventFromMessage(msg)
+	}
+	return events, nil
+}
+
+// readState loads what the user has marked read
+func readState(ctx context.Context, userID string) (string, map[string]bool, error) {
+	cursor, err := rdb.Get(ctx, readCursorPrefix+userID).Result()
+	if err != nil && err != redis.Nil {
+		return "", nil, err
+	}
+	members, err := rdb.SMembers(ctx, readSetPrefix+userID).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	read := make(map[string]bool, len(members))
+	for _, id := range members {
+		read[id] = true
+	}
+	return cursor, read, nil
+}
+
+func isRead(id, cursor string, read map[string]bool) bool {
+	return read[id] || (cursor != "" && !idAfter(id, cursor))
+}
+
+// ListInbox returns up to limit events older than before ("" for the newest),
+// optionally only unread ones
+func ListInbox(ctx context.Context, userID, before string, limit int, unreadOnly bool) (*InboxPage, error) {
+	if rdb == nil {
+		return &InboxPage{Notifications: []InboxItem{}}, nil
+	}
+	cursor, read, err := readState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &InboxPage{Notifications: []InboxItem{}}
+	end := "+"
+	if before != "" {
+		if !isStreamID(before) {
+			return nil, fmt.Errorf("invalid event id %q", before)
+		}
+		end = "(" + before
+	}
+
+	// Walk backwards in batches until the page is full or the inbox is exhausted
+	for len(page.Notifications) < limit {
+		msgs, err := rdb.XRevRangeN(ctx, inboxPrefix+userID, end, "-", int64(limit)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			item := InboxItem{Event: eventFromMessage(msg), Read: isRead(msg.ID, cursor, read)}
+			if unreadOnly && item.Read {
+				continue
+			}
+			page.Notifications = append(page.Notifications, item)
+			if len(page.Notifications) == limit {
+				break
+			}
+		}
+		if len(msgs) < limit {
+			break
+		}
+		end = "(" + msgs[len(msgs)-1].ID
+	}
+	if n := len(page.Notifications); n == limit {
+		page.NextBefore = page.Notifications[n-1].ID
+	}
+
+	page.UnreadCount, err = unreadCount(ctx, userID, cursor, read)
+	return page, err
+}
+
+func unreadCount(ctx context.Context, userID, cursor string, read map[string]bool) (int, error) {
+	start := "-"
+	if cursor != "" {
+		start = "(" + cursor
+	}
+	msgs, err := rdb.XRange(ctx, inboxPrefix+userID, start, "+").Result()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, msg := range msgs {
+		if !read[msg.ID] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MarkRead marks one stored event as read. It reports false if the event is not in the inbox.
+func MarkRead(ctx context.Context, userID, eventID string) (bool, error) {
+	if rdb == nil || !isStreamID(eventID) {
+		return false, nil
+	}
+	msgs, err := rdb.XRange(ctx, inboxPrefix+userID, eventID, eventID).Result()
+	if err != nil || len(msgs) == 0 {
+		return false, err
+	}
+	key := readSetPrefix + userID
+	pipe := rdb.TxPipeline()
+	pipe.SAdd(ctx, key, eventID)
+	pipe.Expire(ctx, key, inboxTTL)
+	_, err = pipe.Exec(ctx)
+	return err == nil, err
+}
+
+// MarkAllRead marks every event currently in the inbox as read
+func MarkAllRead(ctx context.Context, userID string) error {
+	if rdb == nil {
+		return nil
+	}
+	msgs, err := rdb.XRevRangeN(ctx, inboxPrefix+userID, "+", "-", 1).Result()
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, readCursorPrefix+userID, msgs[0].ID, inboxTTL)
+	pipe.Del(ctx, readSetPrefix+userID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// isStreamID reports whether id looks like a Redis stream id ("<ms>-<seq>")
+func isStreamID(id string) bool {
+	_, _, ok := parseStreamID(id)
+	return ok
+}
+
+func parseStreamID(id string) (uint64, uint64, bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	ms, err1 := strconv.ParseUint(msPart, 10, 64)
+	seq, err2 := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq, err1 == nil && err2 == nil
+}
+
+// idAfter reports whether stream id a is newer than b. Ids that are not stream
+// ids (events that were never stored) always count as newer.
+func idAfter(a, b string) bool {
+	ams, aseq, okA := parseStreamID(a)
+	bms, bseq, okB := parseStreamID(b)
+	if !okA || !okB {
+		return true
+	}
+	return ams > bms || (ams == bms && aseq > bseq)
+}
+
+// IsStoredEventID reports whether id names an inbox entry, i.e. an event that
+// can be resumed from with Replay
+func IsStoredEventID(id string) bool {
+	return isStreamID(id)
+}
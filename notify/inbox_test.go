@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func testInbox(t *testing.T, userID string, count int) []string {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	Init(client)
+	t.Cleanup(func() { Init(nil) })
+
+	ids := make([]string, count)
+	for i := range ids {
+		event := NewEvent("file.uploaded", map[string]int{"n": i})
+		if err := storeEvent(context.Background(), userID, &event); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+// replayFrom resumes a session from lastEventID and returns the ids it was sent
+func replayFrom(t *testing.T, userID, lastEventID string) []string {
+	t.Helper()
+	s := NewSession(userID, func(string, []byte) error { return nil }, func(string) {})
+	if err := RegisterResuming(s, lastEventID); err != nil {
+		t.Fatal(err)
+	}
+	defer Unregister(s)
+
+	var sent []string
+	err := Replay(context.Background(), s, func(ctx context.Context, eventID string, message []byte) error {
+		var event Event
+		if err := json.Unmarshal(message, &event); err != nil || event.ID != eventID {
+			t.Fatalf("replayed %s as %s: %v", eventID, message, err)
+		}
+		sent = append(sent, eventID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay from %q: %v", lastEventID, err)
+	}
+	if s.replaying {
+		t.Fatal("session still held back after Replay")
+	}
+	return sent
+}
+
+func TestReplay(t *testing.T) {
+	const user = "a@example.com"
+	ids := testInbox(t, user, 4)
+	ctx := context.Background()
+	if err := MarkAllRead(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	more := NewEvent("file.uploaded", nil)
+	if err := storeEvent(ctx, user, &more); err != nil {
+		t.Fatal(err)
+	}
+	read := NewEvent("file.uploaded", nil)
+	if err := storeEvent(ctx, user, &read); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := MarkRead(ctx, user, read.ID); !ok || err != nil {
+		t.Fatalf("MarkRead = %v, %v", ok, err)
+	}
+	unread := NewEvent("file.uploaded", nil)
+	if err := storeEvent(ctx, user, &unread); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		from string
+		want []string
+	}{
+		{"after a stored event", ids[2], []string{ids[3], more.ID, read.ID, unread.ID}},
+		{"after the newest event", unread.ID, nil},
+		{"after a transient event's id", newEventID(), []string{more.ID, unread.ID}},
+		{"after garbage", "not-an-id", []string{more.ID, unread.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replayFrom(t, user, tt.from)
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestReplayUnreadWithEmptyReadState replays the whole inbox for a transient
+// id when nothing has been marked read
+func TestReplayUnreadWithEmptyReadState(t *testing.T) {
+	ids := testInbox(t, "b@example.com", 3)
+	got := replayFrom(t, "b@example.com", newEventID())
+	if len(got) != len(ids) {
+		t.Fatalf("replayed %v, want %v", got, ids)
+	}
+}
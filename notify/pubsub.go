@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// channel is the Redis Pub/Sub channel every instance publishes to and listens on
+const channel = "notifications"
+
+// rdb carries events between instances; nil means single instance, local delivery only
+var rdb *redis.Client
+
+// envelope is the Pub/Sub message: the event plus the user it is for
+type envelope struct {
+	UserID string `json:"user_id"`
+	Event  Event  `json:"event"`
+}
+
+// Init routes notifications through Redis so users connected to any instance receive them.
+// Call Listen to consume the channel on this instance.
+func Init(client *redis.Client) {
+	rdb = client
+}
+
+// Publish stores event in the user's inbox and sends it to every session of userID
+// on every instance. If Redis is unavailable the event still reaches the sessions
+// connected to this instance, but is not kept for replay.
+func Publish(userID string, event Event) {
+	if rdb == nil {
+		deliverEncoded(userID, event)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// The inbox stream id becomes the event id, so clients can resume from it
+	if err := storeEvent(ctx, userID, &event); err != nil {
+		log.Printf(" Failed to store %s event for %s: %v\n", event.Type, userID, err)
+	}
+
+	payload, _ := json.Marshal(envelope{UserID: userID, Event: event})
+	if err := rdb.Publish(ctx, channel, payload).Err(); err != nil {
+		log.Printf(" Notification publish failed, delivering locally only: %v\n", err)
+		deliverEncoded(userID, event)
+	}
+}
+
+// PublishTransient sends event to the user's sessions on every instance without
+// storing it, for high-frequency updates such as upload progress
+func PublishTransient(userID string, event Event) {
+	if rdb == nil {
+		deliverEncoded(userID, event)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	payload, _ := json.Marshal(envelope{UserID: userID, Event: event})
+	if err := rdb.Publish(ctx, channel, payload).Err(); err != nil {
+		deliverEncoded(userID, event)
+	}
+}
+
+func deliverEncoded(userID string, event Event) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Printf(" Failed to encode %s event: %v\n", event.Type, err)
+		return
+	}
+	deliverLocal(userID, event, message)
+}
+
+// Listen delivers events published by any instance to the sessions connected here.
+// It resubscribes with backoff whenever the Redis connection drops, until ctx ends.
+func Listen(ctx context.Context) {
+	if rdb == nil {
+		return
+	}
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		pubsub := rdb.Subscribe(ctx, channel)
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			log.Printf(" Notification subscribe failed, retrying in %s: %v\n", backoff, err)
+			sleep(ctx, backoff)
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		log.Println(" Subscribed to notification channel")
+		backoff = time.Second
+
+		for {
+			msg, err := pubsub.ReceiveMessage(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println(" Notification channel dropped, resubscribing:", err)
+				}
+				break
+			}
+			var env envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				log.Println(" Ignoring malformed notification:", err)
+				continue
+			}
+			deliverEncoded(env.UserID, env.Event)
+		}
+		pubsub.Close()
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,260 @@
+package progress
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/notify"
+	"github.com/redis/go-redis/v9"
+)
+
+// Stages an upload moves through, from the first byte to processed thumbnails
+const (
+	StageReceiving   = "receiving"
+	StageStoring     = "storing"
+	StageQueued      = "queued"
+	StageScanning    = "scanning"
+	StageProcessing  = "processing"
+	StageCompleted   = "completed"
+	StageQuarantined = "quarantined"
+	StageFailed      = "failed"
+)
+
+// keyPrefix is the Redis hash holding the progress of one upload
+const keyPrefix = "upload:progress:"
+
+// progressTTL keeps progress queryable for a while after the last update
+const progressTTL = time.Hour
+
+// pushInterval throttles byte-count updates; stage changes are pushed immediately
+const pushInterval = 250 * time.Millisecond
+
+// validID restricts client supplied upload ids
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var rdb *redis.Client
+
+// Init stores progress in Redis so any instance can answer progress queries
+func Init(client *redis.Client) {
+	rdb = client
+}
+
+// Progress is the state of one upload
+type Progress struct {
+	UploadID      string    `json:"upload_id"`
+	Owner         string    `json:"-"`
+	FileID        int       `json:"file_id,omitempty"`
+	Filename      string    `json:"filename,omitempty"`
+	Stage         string    `json:"stage"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesTotal    int64     `json:"bytes_total"` // request Content-Length, 0 if unknown
+	S3BytesSent   int64     `json:"s3_bytes_sent"`
+	S3BytesTotal  int64     `json:"s3_bytes_total"`
+	Error         string    `json:"error,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Tracker records the progress of an upload handled by this process
+type Tracker struct {
+	mu       sync.Mutex
+	p        Progress
+	lastPush time.Time
+}
+
+// NewUploadID returns requested if it is a usable id, or a fresh random one
+func NewUploadID(requested string) string {
+	if validID.MatchString(requested) {
+		return requested
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start begins tracking an upload. If the id is already used by another user a
+// fresh one is assigned; check Tracker.ID for the id in effect.
+func Start(ctx context.Context, uploadID, owner string, total int64) *Tracker {
+	if rdb != nil {
+		existing, err := rdb.HGet(ctx, keyPrefix+uploadID, "owner").Result()
+		if err == nil && existing != owner {
+			uploadID = NewUploadID("")
+		}
+	}
+	t := &Tracker{p: Progress{UploadID: uploadID, Owner: owner, Stage: StageReceiving, BytesTotal: total}}
+	t.flush(true)
+	return t
+}
+
+// ID returns the upload id
+func (t *Tracker) ID() string {
+	return t.p.UploadID
+}
+
+// AddReceived counts bytes read from the client
+func (t *Tracker) AddReceived(n int64) {
+	t.mu.Lock()
+	t.p.BytesReceived += n
+	t.mu.Unlock()
+	t.flush(false)
+}
+
+// SetSent records how far the S3 upload has got
+func (t *Tracker) SetSent(sent, total int64) {
+	t.mu.Lock()
+	t.p.S3BytesSent, t.p.S3BytesTotal = sent, total
+	t.mu.Unlock()
+	t.flush(false)
+}
+
+// SetFile records which file the upload became
+func (t *Tracker) SetFile(fileID int, filename string) {
+	t.mu.Lock()
+	t.p.FileID, t.p.Filename = fileID, filename
+	t.mu.Unlock()
+}
+
+// SetStage moves the upload to a new stage and pushes the change immediately
+func (t *Tracker) SetStage(stage string) {
+	t.mu.Lock()
+	t.p.Stage = stage
+	t.mu.Unlock()
+	t.flush(true)
+}
+
+// Fail marks the upload failed with a reason
+func (t *Tracker) Fail(reason string) {
+	t.mu.Lock()
+	t.p.Stage, t.p.Error = StageFailed, reason
+	t.mu.Unlock()
+	t.flush(true)
+}
+
+// flush stores and pushes the current state, at most once per pushInterval unless forced
+func (t *Tracker) flush(force bool) {
+	t.mu.Lock()
+	now := time.Now()
+	if !force && now.Sub(t.lastPush) < pushInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastPush = now
+	t.p.UpdatedAt = now.UTC()
+	snapshot := t.p
+	t.mu.Unlock()
+
+	save(context.Background(), snapshot)
+	notify.PublishTransient(snapshot.Owner, notify.NewEvent(notify.TypeUploadProgress, snapshot))
+}
+
+func save(ctx context.Context, p Progress) {
+	if rdb == nil {
+		return
+	}
+	key := keyPrefix + p.UploadID
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"owner":          p.Owner,
+		"file_id":        p.FileID,
+		"filename":       p.Filename,
+		"stage":          p.Stage,
+		"bytes_received": p.BytesReceived,
+		"bytes_total":    p.BytesTotal,
+		"s3_bytes_sent":  p.S3BytesSent,
+		"s3_bytes_total": p.S3BytesTotal,
+		"error":          p.Error,
+		"updated_at":     p.UpdatedAt.Format(time.RFC3339Nano),
+	})
+	pipe.Expire(ctx, key, progressTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf(" Failed to store progress for upload %s: %v\n", p.UploadID, err)
+	}
+}
+
+// Get loads the progress of an upload, or nil if it is unknown or expired
+func Get(ctx context.Context, uploadID string) (*Progress, error) {
+	if rdb == nil {
+		return nil, nil
+	}
+	fields, err := rdb.HGetAll(ctx, keyPrefix+uploadID).Result()
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	num := func(name string) int64 {
+		n, _ := strconv.ParseInt(fields[name], 10, 64)
+		return n
+	}
+	p := &Progress{
+		UploadID:      uploadID,
+		Owner:         fields["owner"],
+		FileID:        int(num("file_id")),
+		Filename:      fields["filename"],
+		Stage:         fields["stage"],
+		BytesReceived: num("bytes_received"),
+		BytesTotal:    num("bytes_total"),
+		S3BytesSent:   num("s3_bytes_sent"),
+		S3BytesTotal:  num("s3_bytes_total"),
+		Error:         fields["error"],
+	}
+	p.UpdatedAt, _ = time.Parse(time.RFC3339Nano, fields["updated_at"])
+	return p, nil
+}
+
+// SetStage updates the stage of an upload tracked by any instance, e.g. from a
+// background job, and pushes the change to the owner
+func SetStage(ctx context.Context, uploadID, stage, errMsg string) {
+	p, err := Get(ctx, uploadID)
+	if err != nil || p == nil {
+		return
+	}
+	p.Stage, p.Error = stage, errMsg
+	p.UpdatedAt = time.Now().UTC()
+	save(ctx, *p)
+	notify.PublishTransient(p.Owner, notify.NewEvent(notify.TypeUploadProgress, p))
+}
+
+// Reader counts bytes read through it as received from the client
+type Reader struct {
+	R io.Reader
+	T *Tracker
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	if n > 0 {
+		r.T.AddReceived(int64(n))
+	}
+	return n, err
+}
+
+// File wraps the saved upload while it is sent to S3, counting bytes read.
+// The SDK may read the body more than once (checksums, retries), so seeking
+// rewinds the count to the new offset.
+type File struct {
+	*os.File
+	T    *Tracker
+	Size int64
+	pos  int64
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+	f.T.SetSent(f.pos, f.Size)
+	return n, err
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,19 @@
+package queue
+
+// Job types and their payloads
+const (
+	TypeProcessUpload  = "process_upload"
+	TypeDeliverWebhook = "deliver_webhook"
+)
+
+// ProcessUploadPayload asks the upload pipeline to post-process a stored file
+type ProcessUploadPayload struct {
+	FileID   int    `json:"file_id"`
+	Filename string `json:"filename"`
+	UploadID string `json:"upload_id,omitempty"`
+}
+
+// DeliverWebhookPayload asks for one webhook delivery to be attempted
+type DeliverWebhookPayload struct {
+	DeliveryID int `json:"delivery_id"`
+}
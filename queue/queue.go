@@ -0,0 +1,489 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	mrand "math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys used by the queue
+const (
+	streamKey    = "jobs:stream"  // pending jobs, consumed through a consumer group
+	delayedKey   = "jobs:delayed" // ZSET of jobs waiting out a retry backoff, scored by run time
+	deadKey      = "jobs:dead"    // stream of jobs that exhausted their attempts
+	groupName    = "job-workers"
+	statusPrefix = "job:"       // hash with the status of one job
+	filePrefix   = "file:jobs:" // list of recent job ids for a file
+)
+
+// Job states reported by Status
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateRetrying  = "retrying"
+	StateSucceeded = "succeeded"
+	StateDead      = "dead"
+)
+
+// statusTTL is how long job statuses stay queryable after their last update
+const statusTTL = 7 * 24 * time.Hour
+
+// Job is one unit of background work
+type Job struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Owner     string          `json:"owner,omitempty"`
+	FileID    int             `json:"file_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Meta ties a job to the user and file it belongs to, for status lookups
+type Meta struct {
+	Owner  string
+	FileID int
+}
+
+// Status is the externally visible state of a job
+type Status struct {
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`
+	State     string     `json:"state"`
+	Attempts  int        `json:"attempts"`
+	FileID    int        `json:"file_id,omitempty"`
+	Owner     string     `json:"-"`
+	Error     string     `json:"error,omitempty"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// HandlerFunc processes a job. Returning an error schedules a retry.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// DeadHandlerFunc is called once a job has exhausted its attempts
+type DeadHandlerFunc func(ctx context.Context, job *Job, err error)
+
+// Options tune retries and delivery
+type Options struct {
+	MaxAttempts       int
+	BaseBackoff       time.Duration
+	MaxBackoff        time.Duration
+	VisibilityTimeout time.Duration // a job not acknowledged within this is handed to another worker
+	Concurrency       int
+}
+
+// DefaultOptions are used for any zero field in Options
+var DefaultOptions = Options{
+	MaxAttempts:       5,
+	BaseBackoff:       2 * time.Second,
+	MaxBackoff:        10 * time.Minute,
+	VisibilityTimeout: 5 * time.Minute,
+	Concurrency:       2,
+}
+
+// Queue is a durable job queue on Redis Streams. Any number of processes may
+// enqueue and run workers; each job is delivered to one worker at a time.
+type Queue struct {
+	rdb      *redis.Client
+	opts     Options
+	consumer string
+
+	mu           sync.RWMutex
+	handlers     map[string]HandlerFunc
+	deadHandlers map[string]DeadHandlerFunc
+}
+
+// New creates a queue on rdb
+func New(rdb *redis.Client, opts Options) *Queue {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = DefaultOptions.BaseBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = DefaultOptions.VisibilityTimeout
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultOptions.Concurrency
+	}
+
+	host, _ := os.Hostname()
+	return &Queue{
+		rdb:          rdb,
+		opts:         opts,
+		consumer:     fmt.Sprintf("%s-%d", host, os.Getpid()),
+		handlers:     make(map[string]HandlerFunc),
+		deadHandlers: make(map[string]DeadHandlerFunc),
+	}
+}
+
+// Register sets the handler for a job type
+func (q *Queue) Register(jobType string, h HandlerFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = h
+}
+
+// OnDead sets a callback for jobs of a type that end up in the dead-letter queue
+func (q *Queue) OnDead(jobType string, h DeadHandlerFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadHandlers[jobType] = h
+}
+
+// Enqueue stores a job durably and returns it. The payload is marshalled to JSON.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any, meta Meta) (*Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %v", jobType, err)
+	}
+	job := &Job{
+		ID:        newJobID(),
+		Type:      jobType,
+		Payload:   raw,
+		Owner:     meta.Owner,
+		FileID:    meta.FileID,
+		CreatedAt: time.Now().UTC(),
+	}
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := q.rdb.TxPipeline()
+	statusKey := statusPrefix + job.ID
+	pipe.HSet(ctx, statusKey, map[string]interface{}{
+		"type":       job.Type,
+		"state":      StateQueued,
+		"attempts":   0,
+		"owner":      job.Owner,
+		"file_id":    job.FileID,
+		"updated_at": job.CreatedAt.Format(time.RFC3339Nano),
+	})
+	pipe.Expire(ctx, statusKey, statusTTL)
+	if job.FileID != 0 {
+		fileKey := filePrefix + strconv.Itoa(job.FileID)
+		pipe.LPush(ctx, fileKey, job.ID)
+		pipe.LTrim(ctx, fileKey, 0, 19)
+		pipe.Expire(ctx, fileKey, statusTTL)
+	}
+	pipe.XAdd(ctx, &redis.XAddArgs{Stream: streamKey, Values: map[string]interface{}{"job": encoded}})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to enqueue %s job: %v", jobType, err)
+	}
+	return job, nil
+}
+
+// Decode unmarshals a job payload into T
+func Decode[T any](job *Job) (T, error) {
+	var payload T
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return payload, fmt.Errorf("invalid %s payload: %v", job.Type, err)
+	}
+	return payload, nil
+}
+
+// GetStatus returns the status of a job, or nil if it is unknown or expired
+func (q *Queue) GetStatus(ctx context.Context, jobID string) (*Status, error) {
+	fields, err := q.rdb.HGetAll(ctx, statusPrefix+jobID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	status := &Status{
+		ID:    jobID,
+		Type:  fields["type"],
+		State: fields["state"],
+		Owner: fields["owner"],
+		Error: fields["error"],
+	}
+	status.Attempts, _ = strconv.Atoi(fields["attempts"])
+	status.FileID, _ = strconv.Atoi(fields["file_id"])
+	status.UpdatedAt, _ = time.Parse(time.RFC3339Nano, fields["updated_at"])
+	if next, err := time.Parse(time.RFC3339Nano, fields["next_run_at"]); err == nil && status.State == StateRetrying {
+		status.NextRunAt = &next
+	}
+	return status, nil
+}
+
+// FileStatuses returns the statuses of the most recent jobs for a file, newest first
+func (q *Queue) FileStatuses(ctx context.Context, fileID int) ([]*Status, error) {
+	ids, err := q.rdb.LRange(ctx, filePrefix+strconv.Itoa(fileID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*Status, 0, len(ids))
+	for _, id := range ids {
+		status, err := q.GetStatus(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if status != nil {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// Run consumes jobs until ctx is cancelled. It also promotes retries whose
+// backoff has elapsed and reclaims jobs abandoned by crashed workers.
+func (q *Queue) Run(ctx context.Context) {
+	err := q.rdb.XGroupCreateMkStream(ctx, streamKey, groupName, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		log.Println(" Failed to create job consumer group:", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2 + q.opts.Concurrency)
+	go func() { defer wg.Done(); q.promoteDelayed(ctx) }()
+	go func() { defer wg.Done(); q.reclaimStale(ctx) }()
+	for i := 0; i < q.opts.Concurrency; i++ {
+		go func() { defer wg.Done(); q.consume(ctx) }()
+	}
+	log.Printf(" Job queue worker %s started (%d workers)\n", q.consumer, q.opts.Concurrency)
+	wg.Wait()
+}
+
+func (q *Queue) consume(ctx context.Context) {
+	for ctx.Err() == nil {
+		streams, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    groupName,
+			Consumer: q.consumer,
+			Streams:  []string{streamKey, ">"},
+			Count:    1,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			if strings.Contains(err.Error(), "NOGROUP") {
+				q.rdb.XGroupCreateMkStream(ctx, streamKey, groupName, "0")
+			}
+			log.Println(" Job queue read error:", err)
+			sleep(ctx, time.Second)
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				q.process(ctx, msg)
+			}
+		}
+	}
+}
+
+// process runs one delivered message and then acknowledges it, scheduling a
+// retry or dead-lettering it if the handler failed
+func (q *Queue) process(ctx context.Context, msg redis.XMessage) {
+	raw, _ := msg.Values["job"].(string)
+	var job Job
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		log.Printf(" Dropping malformed job %s: %v\n", msg.ID, err)
+		q.rdb.XAdd(ctx, &redis.XAddArgs{Stream: deadKey, MaxLen: 10000, Approx: true,
+			Values: map[string]interface{}{"job": raw, "error": "malformed job: " + err.Error()}})
+		q.ack(ctx, msg.ID)
+		return
+	}
+
+	statusKey := statusPrefix + job.ID
+	attempts, err := q.rdb.HIncrBy(ctx, statusKey, "attempts", 1).Result()
+	if err != nil {
+		log.Printf(" Job %s status update failed: %v\n", job.ID, err)
+	}
+	q.setStatus(ctx, job.ID, map[string]interface{}{"state": StateRunning})
+
+	err = q.runHandler(ctx, msg.ID, &job)
+	if err == nil {
+		q.setStatus(ctx, job.ID, map[string]interface{}{"state": StateSucceeded, "error": ""})
+		q.ack(ctx, msg.ID)
+		return
+	}
+
+	if int(attempts) >= q.opts.MaxAttempts {
+		log.Printf(" Job %s (%s) failed permanently after %d attempts: %v\n", job.ID, job.Type, attempts, err)
+		pipe := q.rdb.TxPipeline()
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: deadKey, MaxLen: 10000, Approx: true,
+			Values: map[string]interface{}{"job": raw, "error": err.Error()}})
+		pipe.XAck(ctx, streamKey, groupName, msg.ID)
+		pipe.XDel(ctx, streamKey, msg.ID)
+		if _, perr := pipe.Exec(ctx); perr != nil {
+			log.Printf(" Failed to dead-letter job %s: %v\n", job.ID, perr)
+		}
+		q.setStatus(ctx, job.ID, map[string]interface{}{"state": StateDead, "error": err.Error()})
+
+		q.mu.RLock()
+		onDead := q.deadHandlers[job.Type]
+		q.mu.RUnlock()
+		if onDead != nil {
+			onDead(ctx, &job, err)
+		}
+		return
+	}
+
+	nextRun := time.Now().Add(q.backoff(int(attempts)))
+	log.Printf(" Job %s (%s) attempt %d failed, retrying at %s: %v\n", job.ID, job.Type, attempts, nextRun.Format(time.RFC3339), err)
+	pipe := q.rdb.TxPipeline()
+	pipe.ZAdd(ctx, delayedKey, redis.Z{Score: float64(nextRun.UnixMilli()), Member: raw})
+	pipe.XAck(ctx, streamKey, groupName, msg.ID)
+	pipe.XDel(ctx, streamKey, msg.ID)
+	if _, perr := pipe.Exec(ctx); perr != nil {
+		log.Printf(" Failed to schedule retry for job %s: %v\n", job.ID, perr)
+	}
+	q.setStatus(ctx, job.ID, map[string]interface{}{
+		"state":       StateRetrying,
+		"error":       err.Error(),
+		"next_run_at": nextRun.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// runHandler calls the job's handler, recovering panics and keeping the
+// message claimed while the handler is still busy
+func (q *Queue) runHandler(ctx context.Context, msgID string, job *Job) (err error) {
+	q.mu.RLock()
+	h, ok := q.handlers[job.Type]
+	q.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler registered for job type %q", job.Type)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(q.opts.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// Re-claiming our own message resets its idle time
+				q.rdb.XClaim(ctx, &redis.XClaimArgs{Stream: streamKey, Group: groupName, Consumer: q.consumer, Messages: []string{msgID}})
+			}
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// promoteDelayed moves retries whose backoff has elapsed back onto the stream
+func (q *Queue) promoteDelayed(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := q.rdb.ZRangeByScore(ctx, delayedKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: 100,
+		}).Result()
+		if err != nil && ctx.Err() == nil {
+			log.Println(" Job queue delayed scan error:", err)
+		}
+		for _, raw := range due {
+			// Only the instance that wins the ZREM re-enqueues the job
+			if removed, err := q.rdb.ZRem(ctx, delayedKey, raw).Result(); err != nil || removed == 0 {
+				continue
+			}
+			if err := q.rdb.XAdd(ctx, &redis.XAddArgs{Stream: streamKey, Values: map[string]interface{}{"job": raw}}).Err(); err != nil {
+				log.Println(" Failed to requeue delayed job:", err)
+				q.rdb.ZAdd(ctx, delayedKey, redis.Z{Score: float64(time.Now().UnixMilli()), Member: raw})
+				continue
+			}
+			var job Job
+			if json.Unmarshal([]byte(raw), &job) == nil {
+				q.setStatus(ctx, job.ID, map[string]interface{}{"state": StateQueued})
+			}
+		}
+		sleep(ctx, time.Second)
+	}
+}
+
+// reclaimStale takes over messages whose worker stopped acknowledging them
+// within the visibility timeout, e.g. because the process died
+func (q *Queue) reclaimStale(ctx context.Context) {
+	for ctx.Err() == nil {
+		sleep(ctx, q.opts.VisibilityTimeout/2)
+		msgs, _, err := q.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   streamKey,
+			Group:    groupName,
+			Consumer: q.consumer,
+			MinIdle:  q.opts.VisibilityTimeout,
+			Start:    "0-0",
+			Count:    50,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, redis.Nil) {
+				log.Println(" Job queue reclaim error:", err)
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			log.Printf(" Reclaimed stalled job message %s\n", msg.ID)
+			q.process(ctx, msg)
+		}
+	}
+}
+
+func (q *Queue) ack(ctx context.Context, msgID string) {
+	pipe := q.rdb.TxPipeline()
+	pipe.XAck(ctx, streamKey, groupName, msgID)
+	pipe.XDel(ctx, streamKey, msgID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf(" Failed to acknowledge job message %s: %v\n", msgID, err)
+	}
+}
+
+func (q *Queue) setStatus(ctx context.Context, jobID string, fields map[string]interface{}) {
+	fields["updated_at"] = time.Now().UTC().Format(time.RFC3339Nano)
+	statusKey := statusPrefix + jobID
+	pipe := q.rdb.TxPipeline()
+	pipe.HSet(ctx, statusKey, fields)
+	pipe.Expire(ctx, statusKey, statusTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf(" Job %s status update failed: %v\n", jobID, err)
+	}
+}
+
+// backoff returns the exponential delay before the given retry, with up to 20% jitter
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := float64(q.opts.BaseBackoff) * math.Pow(2, float64(attempt-1))
+	if delay > float64(q.opts.MaxBackoff) {
+		delay = float64(q.opts.MaxBackoff)
+	}
+	return time.Duration(delay * (1 + 0.2*mrand.Float64()))
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
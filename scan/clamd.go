@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is how much of the file goes in each INSTREAM chunk; it must
+// stay below clamd's StreamMaxLength
+const clamdChunkSize = 64 << 10
+
+// Clamd scans with a ClamAV daemon over its INSTREAM protocol
+type Clamd struct {
+	Network string // "tcp" or "unix"
+	Address string
+	Timeout time.Duration // for the whole scan, including sending the file
+}
+
+// NewClamd returns a scanner for the clamd at address, which is a unix socket
+// when it is a path and a TCP host:port otherwise
+func NewClamd(address string) *Clamd {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+	return &Clamd{Network: network, Address: address, Timeout: 2 * time.Minute}
+}
+
+// Name identifies the scanner in scan records
+func (c *Clamd) Name() string { return "clamd" }
+
+// Scan streams r to clamd in length-prefixed chunks and parses its verdict
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to reach clamd at %s: %v", c.Address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("failed to start clamd scan: %v", err)
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd hangs up once the stream passes its size limit; its reply says so
+				break
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, fmt.Errorf("failed to read file for scanning: %v", readErr)
+		}
+	}
+	conn.Write([]byte{0, 0, 0, 0}) // zero-length chunk ends the stream
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil && len(reply) == 0 {
+		return Result{}, fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads "stream: OK", "stream: <signature> FOUND" or "<message> ERROR"
+func parseClamdReply(reply []byte) (Result, error) {
+	line := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+	status := strings.TrimPrefix(line, "stream: ")
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	case line == "":
+		return Result{}, fmt.Errorf("clamd closed the connection without a verdict")
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", line)
+	}
+}
@@ -0,0 +1,34 @@
+package scan
+
+import "testing"
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{"clean", "stream: OK\x00", false, "", false},
+		{"clean without a terminator", "stream: OK\n", false, "", false},
+		{"infected", "stream: Eicar-Test-Signature FOUND\x00", true, "Eicar-Test-Signature", false},
+		{"signature with spaces", "stream: Win.Test.EICAR_HDB-1 (bytecode) FOUND\x00", true, "Win.Test.EICAR_HDB-1 (bytecode)", false},
+		{"size limit", "INSTREAM size limit exceeded. ERROR\x00", false, "", true},
+		{"scan error", "stream: Can't allocate memory ERROR\x00", false, "", true},
+		{"empty reply", "", false, "", true},
+		{"only terminators", "\x00\x00", false, "", true},
+		{"OK inside a longer message", "stream: NOT OK\x00", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseClamdReply([]byte(tt.reply))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClamdReply(%q) error = %v, want error %v", tt.reply, err, tt.wantErr)
+			}
+			if result.Infected != tt.infected || result.Signature != tt.signature {
+				t.Fatalf("parseClamdReply(%q) = %+v, want infected %v, signature %q", tt.reply, result, tt.infected, tt.signature)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// EICAR is the standard antivirus test string; every real scanner reports it
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// Fake is a Scanner for development and tests that needs no daemon. It reports
+// files containing EICAR (or any of Signatures) as infected, fails with Err
+// when set, and remembers how many files it has seen.
+type Fake struct {
+	Signatures map[string]string // content marker -> signature name
+	Err        error
+
+	mu      sync.Mutex
+	scanned int
+}
+
+// Name identifies the scanner in scan records
+func (f *Fake) Name() string { return "fake" }
+
+// Scan reads all of r and looks for the known markers
+func (f *Fake) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	f.mu.Lock()
+	f.scanned++
+	err := f.Err
+	f.mu.Unlock()
+	if err != nil {
+		return Result{}, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	if bytes.Contains(data, []byte(EICAR)) {
+		return Result{Infected: true, Signature: "Eicar-Signature"}, nil
+	}
+	for marker, signature := range f.Signatures {
+		if bytes.Contains(data, []byte(marker)) {
+			return Result{Infected: true, Signature: signature}, nil
+		}
+	}
+	return Result{}, nil
+}
+
+// Scanned returns how many files Scan has been called with
+func (f *Fake) Scanned() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.scanned
+}
@@ -0,0 +1,48 @@
+// Package scan checks uploaded files for malware before they can be downloaded
+package scan
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+)
+
+// Result is the verdict on one file
+type Result struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature,omitempty"` // what was found, e.g. "Eicar-Signature"
+}
+
+// Scanner inspects a file's content. An error means no verdict was reached and
+// the file must stay held; it is not a sign the file is infected.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// FromEnv returns the scanner chosen by SCANNER: "clamd" (at CLAMD_ADDRESS,
+// a host:port or a unix socket path), "fake" for development, or nil when
+// unset, in which case uploads are available without scanning
+func FromEnv() Scanner {
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("SCANNER"))); kind {
+	case "":
+		return nil
+	case "clamd":
+		address := os.Getenv("CLAMD_ADDRESS")
+		if address == "" {
+			address = "localhost:3310"
+		}
+		c := NewClamd(address)
+		c.Timeout = config.GetEnvDuration("CLAMD_TIMEOUT", c.Timeout)
+		return c
+	case "fake":
+		return &Fake{}
+	default:
+		log.Fatalf("Unknown SCANNER %q: expected clamd or fake", kind)
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on what users can attach to a file
+const (
+	MaxTags              = 50
+	MaxTagLength         = 50
+	MaxDescriptionLength = 4000
+	MaxMetadataKeys      = 50
+	MaxMetadataValue     = 1024
+)
+
+// metadataKey allows keys that are safe to use as ?meta.<key>= filters
+var metadataKey = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// NormalizeTag lowercases a tag and collapses its whitespace, so "Q3  Report"
+// and "q3 report" are the same tag
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" {
+		return "", fmt.Errorf("tags must not be empty")
+	}
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d characters", tag, MaxTagLength)
+	}
+	for _, r := range tag {
+		if unicode.IsControl(r) || r == ',' {
+			return "", fmt.Errorf("tag %q contains an invalid character", tag)
+		}
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes each tag and returns them sorted without duplicates
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// ValidateMetadataKey checks a custom metadata key
+func ValidateMetadataKey(key string) error {
+	if !metadataKey.MatchString(key) {
+		return fmt.Errorf("metadata key %q must be 1-64 letters, digits, '.', '_' or '-'", key)
+	}
+	return nil
+}
+
+// ValidateMetadataValue checks a custom metadata value
+func ValidateMetadataValue(key, value string) error {
+	if len(value) > MaxMetadataValue {
+		return fmt.Errorf("metadata value of %q is longer than %d bytes", key, MaxMetadataValue)
+	}
+	if !utf8.ValidString(value) || strings.ContainsRune(value, 0) {
+		return fmt.Errorf("metadata value of %q is not valid text", key)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// SHA256 reads r to the end and returns its hex SHA-256 and length
+func SHA256(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// SHA256Base64 converts a hex SHA-256 to the base64 form used by S3 checksums
+// and HTTP digest headers
+func SHA256Base64(hexSum string) (string, error) {
+	raw, err := hex.DecodeString(hexSum)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 %q", hexSum)
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
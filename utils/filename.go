@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// uploadPrefix matches the "<unix>_" prefix UploadFile adds to stored filenames
+var uploadPrefix = regexp.MustCompile(`^\d+_`)
+
+// maxFilenameLength is the longest name, in bytes, a file can be given
+const maxFilenameLength = 255
+
+// ValidateFilename rejects names a file cannot be given: empty ones, ones
+// longer than maxFilenameLength, and ones that could be read as a path or that
+// hold control characters
+func ValidateFilename(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return errors.New("filename is empty")
+	case len(name) > maxFilenameLength:
+		return fmt.Errorf("filename is longer than %d bytes", maxFilenameLength)
+	case strings.ContainsAny(name, `/\`) || strings.Contains(name, ".."):
+		return errors.New("filename cannot contain '/', '\\' or '..'")
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return errors.New("filename cannot contain control characters")
+	}
+	return nil
+}
+
+// DisplayName returns the name a file was uploaded with, without the storage prefix
+func DisplayName(storedName string) string {
+	if name := uploadPrefix.ReplaceAllString(storedName, ""); name != "" {
+		return name
+	}
+	return storedName
+}
+
+// ContentDisposition builds a Content-Disposition header value per RFC 6266.
+// The quoted filename carries an ASCII fallback; names that need more than that
+// also get an RFC 5987 filename* parameter with the exact UTF-8 name.
+func ContentDisposition(dispositionType, filename string) string {
+	fallback := asciiFilename(filename)
+	value := fmt.Sprintf(`%s; filename="%s"`, dispositionType, fallback)
+	if fallback != filename {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// asciiFilename replaces characters that cannot appear in a quoted-string filename
+func asciiFilename(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('_')
+		case r < 0x20 || r >= 0x7f:
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// encodeRFC5987 percent-encodes every byte outside the RFC 5987 attr-char set
+func encodeRFC5987(value string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
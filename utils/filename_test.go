@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"report.pdf", false},
+		{"Quarterly report (final).pdf", false},
+		{"résumé.docx", false},
+		{".env", false},
+		{strings.Repeat("a", 255), false},
+		{"", true},
+		{"   ", true},
+		{strings.Repeat("a", 256), true},
+		{"../../etc/passwd", true},
+		{"dir/report.pdf", true},
+		{`dir\report.pdf`, true},
+		{"..", true},
+		{"report..pdf", true},
+		{"tab\there.txt", true},
+		{"nul\x00.txt", true},
+		{"next\u0085line.txt", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateFilename(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateFilename(%q) = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	for stored, want := range map[string]string{
+		"1700000000_report.pdf": "report.pdf",
+		"report.pdf":            "report.pdf",
+		"1700000000_":           "1700000000_",
+		"12_34_notes.txt":       "34_notes.txt",
+	} {
+		if got := DisplayName(stored); got != want {
+			t.Errorf("DisplayName(%q) = %q, want %q", stored, got, want)
+		}
+	}
+}
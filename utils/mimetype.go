@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SniffLength is how much of a file DetectContentType looks at
+const SniffLength = 512
+
+// Media types of native executables, which have no text or document form
+const (
+	TypeWindowsExecutable = "application/x-msdownload"
+	TypeELFExecutable     = "application/x-executable"
+	TypeMachOExecutable   = "application/x-mach-binary"
+	TypeShellScript       = "text/x-shellscript"
+)
+
+// extensionTypes covers common extensions that the standard library table
+// lacks or that differ between systems' mime.types files
+var extensionTypes = map[string]string{
+	".pdf": "application/pdf", ".zip": "application/zip", ".gz": "application/gzip", ".tgz": "application/gzip",
+	".tar": "application/x-tar", ".7z": "application/x-7z-compressed", ".rar": "application/vnd.rar",
+	".png": "image/png", ".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".gif": "image/gif",
+	".webp": "image/webp", ".bmp": "image/bmp", ".ico": "image/x-icon", ".svg": "image/svg+xml",
+	".mp3": "audio/mpeg", ".wav": "audio/wav", ".ogg": "audio/ogg", ".m4a": "audio/mp4",
+	".mp4": "video/mp4", ".mov": "video/quicktime", ".webm": "video/webm", ".mkv": "video/x-matroska",
+	".txt": "text/plain", ".log": "text/plain", ".md": "text/markdown", ".csv": "text/csv",
+	".tsv": "text/tab-separated-values", ".json": "application/json", ".xml": "application/xml",
+	".yaml": "application/yaml", ".yml": "application/yaml", ".html": "text/html", ".htm": "text/html",
+	".doc": "application/msword", ".xls": "application/vnd.ms-excel", ".ppt": "application/vnd.ms-powerpoint",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text", ".ods": "application/vnd.oasis.opendocument.spreadsheet",
+	".epub": "application/epub+zip", ".jar": "application/java-archive", ".apk": "application/vnd.android.package-archive",
+	".exe": TypeWindowsExecutable, ".dll": TypeWindowsExecutable, ".msi": "application/x-msi",
+	".sh": TypeShellScript,
+}
+
+// zipContainers are formats stored as ZIP archives; their magic number is the
+// ZIP one, so the extension decides which of them a ZIP file is
+var zipContainers = map[string]bool{
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true,
+	".epub": true, ".jar": true, ".apk": true,
+}
+
+// executableExtensions may legitimately hold native executables
+var executableExtensions = map[string]bool{
+	".exe": true, ".dll": true, ".com": true, ".scr": true, ".sys": true, ".msi": true,
+	".bin": true, ".elf": true, ".so": true, ".dylib": true, ".app": true, ".out": true, "": true,
+}
+
+// typeAliases maps the names http.DetectContentType uses to the ones in extensionTypes
+var typeAliases = map[string]string{
+	"application/x-gzip":           "application/gzip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"audio/wave":                   "audio/wav",
+	"text/xml":                     "application/xml",
+}
+
+// ContentTypeByName guesses a media type from a file's extension, without
+// parameters such as charset
+func ContentTypeByName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mediaType, ok := extensionTypes[ext]; ok {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// DetectContentType identifies a file from its first SniffLength bytes. Where
+// the bytes cannot tell formats apart (text, or the many ZIP based formats) the
+// extension of name picks among the compatible types.
+func DetectContentType(head []byte, name string) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return TypeWindowsExecutable
+	case bytes.HasPrefix(head, []byte("\x7fELF")):
+		return TypeELFExecutable
+	case bytes.HasPrefix(head, []byte{0xFE, 0xED, 0xFA, 0xCE}), bytes.HasPrefix(head, []byte{0xFE, 0xED, 0xFA, 0xCF}),
+		bytes.HasPrefix(head, []byte{0xCE, 0xFA, 0xED, 0xFE}), bytes.HasPrefix(head, []byte{0xCF, 0xFA, 0xED, 0xFE}):
+		return TypeMachOExecutable
+	case bytes.HasPrefix(head, []byte("#!")):
+		return TypeShellScript
+	}
+
+	detected, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		detected = "application/octet-stream"
+	}
+	if alias, ok := typeAliases[detected]; ok {
+		detected = alias
+	}
+
+	ext := strings.ToLower(path.Ext(name))
+	claimed := ContentTypeByName(name)
+	switch {
+	case detected == "application/zip" && zipContainers[ext]:
+		return claimed
+	case (detected == "text/plain" || detected == "application/xml") && bytes.Contains(head, []byte("<svg")):
+		return "image/svg+xml"
+	case detected == "text/plain" && isTextType(claimed):
+		// Text has no magic number; CSV, JSON, Markdown and so on are told apart by name
+		return claimed
+	case detected == "application/octet-stream" && !verifiable(claimed):
+		// Unrecognised bytes: trust the extension for formats we have no signature for
+		return claimed
+	}
+	return detected
+}
+
+// ContentTypeMismatchError is returned when a file's bytes contradict its extension
+type ContentTypeMismatchError struct {
+	Name     string
+	Claimed  string
+	Detected string
+}
+
+func (e *ContentTypeMismatchError) Error() string {
+	return fmt.Sprintf("%s looks like %s, not %s as its extension suggests", e.Name, e.Detected, e.Claimed)
+}
+
+// CheckExtension rejects files whose content contradicts their extension:
+// executables under a non-executable name, and formats with a known signature
+// (PDF, images, archives, Office documents) that do not carry it
+func CheckExtension(name, detected string) error {
+	ext := strings.ToLower(path.Ext(name))
+	claimed := ContentTypeByName(name)
+	mismatch := &ContentTypeMismatchError{Name: DisplayName(path.Base(name)), Claimed: claimed, Detected: detected}
+
+	if IsExecutableType(detected) && detected != TypeShellScript && !executableExtensions[ext] {
+		return mismatch
+	}
+	if verifiable(claimed) && detected != claimed {
+		return mismatch
+	}
+	return nil
+}
+
+// IsExecutableType reports whether mediaType is a native executable or script
+func IsExecutableType(mediaType string) bool {
+	switch mediaType {
+	case TypeWindowsExecutable, TypeELFExecutable, TypeMachOExecutable, TypeShellScript:
+		return true
+	}
+	return false
+}
+
+// verifiable reports whether DetectContentType can positively recognise mediaType
+func verifiable(mediaType string) bool {
+	switch mediaType {
+	case "application/pdf", "application/zip", "application/gzip", "application/vnd.rar",
+		"image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp",
+		TypeWindowsExecutable:
+		return true
+	}
+	for ext := range zipContainers {
+		if extensionTypes[ext] == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func isTextType(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/yaml", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	typePDF  = "application/pdf"
+	typeZIP  = "application/zip"
+	typeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	typeSVG  = "image/svg+xml"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		file string
+		want string
+	}{
+		{"Windows executable", "MZ\x90\x00\x03", "setup.exe", TypeWindowsExecutable},
+		{"Windows executable named as a PDF", "MZ\x90\x00\x03", "report.pdf", TypeWindowsExecutable},
+		{"ELF binary", "\x7fELF\x02\x01\x01", "tool", TypeELFExecutable},
+		{"Mach-O binary", "\xcf\xfa\xed\xfe\x07", "tool", TypeMachOExecutable},
+		{"shell script", "#!/bin/sh\necho hi\n", "run.txt", TypeShellScript},
+		{"PDF", "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n", "report.pdf", typePDF},
+		{"PNG", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "photo.png", "image/png"},
+		{"gzip alias", "\x1f\x8b\x08\x00\x00\x00\x00\x00", "logs.gz", "application/gzip"},
+		{"ZIP named as a document", "PK\x03\x04\x14\x00\x06\x00", "letter.docx", typeDOCX},
+		{"ZIP named as a ZIP", "PK\x03\x04\x14\x00\x06\x00", "bundle.zip", typeZIP},
+		{"ZIP named as text", "PK\x03\x04\x14\x00\x06\x00", "notes.txt", typeZIP},
+		{"SVG named as text", `<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>`, "notes.txt", typeSVG},
+		{"SVG with an XML prolog", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`, "logo.svg", typeSVG},
+		{"CSV told apart by name", "a,b\n1,2\n", "data.csv", "text/csv"},
+		{"JSON told apart by name", `{"a": 1}`, "data.JSON", "application/json"},
+		{"text named as a PDF", "just some words", "report.pdf", "text/plain"},
+		{"unknown bytes trust an unverifiable extension", "\x00\x01\x02\x03\x04", "song.mp3", "audio/mpeg"},
+		{"unknown bytes under a verifiable extension", "\x00\x01\x02\x03\x04", "photo.png", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType([]byte(tt.head), tt.file); got != tt.want {
+				t.Fatalf("DetectContentType(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		detected string
+		wantErr  bool
+	}{
+		{"executable under its own extension", "setup.exe", TypeWindowsExecutable, false},
+		{"executable without an extension", "tool", TypeELFExecutable, false},
+		{"executable named as a PDF", "report.pdf", TypeWindowsExecutable, true},
+		{"executable named as text", "readme.txt", TypeMachOExecutable, true},
+		{"shell script under any name", "run.txt", TypeShellScript, false},
+		{"PDF", "report.pdf", typePDF, false},
+		{"text named as a PDF", "report.pdf", "text/plain", true},
+		{"JPEG named as a PNG", "photo.png", "image/jpeg", true},
+		{"document container", "letter.docx", typeDOCX, false},
+		{"plain ZIP named as a document", "letter.docx", typeZIP, true},
+		{"ZIP named as text", "notes.txt", typeZIP, false},
+		{"SVG named as text", "notes.txt", typeSVG, false},
+		{"uppercase extension", "REPORT.PDF", typePDF, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckExtension(tt.file, tt.detected)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckExtension(%q, %q) = %v, want error %v", tt.file, tt.detected, err, tt.wantErr)
+			}
+			var mismatch *ContentTypeMismatchError
+			if err != nil && !errors.As(err, &mismatch) {
+				t.Fatalf("error %T is not a *ContentTypeMismatchError", err)
+			}
+		})
+	}
+}
+
+func TestContentTypeMismatchErrorUsesDisplayName(t *testing.T) {
+	err := CheckExtension("1700000000_report.pdf", TypeWindowsExecutable)
+	want := "report.pdf looks like application/x-msdownload, not application/pdf as its extension suggests"
+	if err == nil || err.Error() != want {
+		t.Fatalf("CheckExtension = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// maxPDFPreviewScan bounds how much of a PDF is read looking for a preview image
+const maxPDFPreviewScan = 64 << 20
+
+// ExtractPDFPreview returns the first JPEG (DCTDecode) image embedded in a PDF.
+// Rendering PDF pages needs a full PDF engine, which we avoid; scanned documents
+// and most slide exports embed each page as a JPEG, so the first one found is
+// usually the first page. Other PDFs simply get no preview.
+func ExtractPDFPreview(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPDFPreviewScan))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF")
+	}
+
+	for offset := 0; ; {
+		idx := bytes.Index(data[offset:], []byte("/DCTDecode"))
+		if idx < 0 {
+			return nil, fmt.Errorf("no embedded JPEG found")
+		}
+		offset += idx + len("/DCTDecode")
+
+		stream := bytes.Index(data[offset:], []byte("stream"))
+		if stream < 0 {
+			return nil, fmt.Errorf("no embedded JPEG found")
+		}
+		start := offset + stream + len("stream")
+		// The keyword is followed by CRLF or LF before the raw data
+		if start < len(data) && data[start] == '\r' {
+			start++
+		}
+		if start < len(data) && data[start] == '\n' {
+			start++
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			return nil, fmt.Errorf("truncated image stream")
+		}
+
+		raw := data[start : start+end]
+		if cfg, err := jpeg.DecodeConfig(bytes.NewReader(raw)); err == nil && cfg.Width*cfg.Height <= maxSourcePixels {
+			if img, err := jpeg.Decode(bytes.NewReader(raw)); err == nil {
+				return img, nil
+			}
+		}
+		offset = start + end
+	}
+}
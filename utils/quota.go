@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// StorageUsage describes how much of a user or team quota is in use
+type StorageUsage struct {
+	Scope     string `json:"scope"` // "user" or "team"
+	Subject   string `json:"subject"`
+	Used      int64  `json:"bytes_used"`
+	Quota     int64  `json:"quota_bytes"`
+	Remaining int64  `json:"bytes_remaining"`
+}
+
+// QuotaExceededError is returned when an upload would push a user or team over its quota
+type QuotaExceededError struct {
+	Scope     string
+	Used      int64
+	Quota     int64
+	Requested int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("Storage quota exceeded: upload needs at least %d bytes but only %d bytes remain in your %s quota",
+		e.Requested, max(e.Quota-e.Used, 0), e.Scope)
+}
+
+type quotaSubject struct {
+	scope string
+	key   string
+	quota int64
+}
+
+// quotaSubjects returns the user subject followed by the team subject, if the user has a team.
+// The order is fixed so concurrent reservations always lock rows in the same order.
+func quotaSubjects(q Querier, userID string) ([]quotaSubject, error) {
+	var userQuota, teamQuota sql.NullInt64
+	var teamID sql.NullInt64
+	err := q.QueryRow(`SELECT u.quota_bytes, u.team_id, t.quota_bytes
+		FROM users u LEFT JOIN teams t ON t.id = u.team_id
+		WHERE u.email = $1`, userID).Scan(&userQuota, &teamID, &teamQuota)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load quota for %s: %v", userID, err)
+	}
+
+	subjects := []quotaSubject{{scope: "user", key: "user:" + userID, quota: config.DefaultUserQuota()}}
+	if userQuota.Valid {
+		subjects[0].quota = userQuota.Int64
+	}
+	if teamID.Valid {
+		team := quotaSubject{scope: "team", key: fmt.Sprintf("team:%d", teamID.Int64), quota: config.DefaultTeamQuota()}
+		if teamQuota.Valid {
+			team.quota = teamQuota.Int64
+		}
+		subjects = append(subjects, team)
+	}
+	return subjects, nil
+}
+
+// GetStorageUsage reports usage for the user and, if any, the user's team
+func GetStorageUsage(q Querier, userID string) ([]StorageUsage, error) {
+	subjects, err := quotaSubjects(q, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]StorageUsage, 0, len(subjects))
+	for _, s := range subjects {
+		var used int64
+		err := q.QueryRow("SELECT bytes_used FROM storage_usage WHERE subject = $1", s.key).Scan(&used)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to load usage for %s: %v", s.key, err)
+		}
+		usage = append(usage, StorageUsage{
+			Scope:     s.scope,
+			Subject:   s.key,
+			Used:      used,
+			Quota:     s.quota,
+			Remaining: max(s.quota-used, 0),
+		})
+	}
+	return usage, nil
+}
+
+// RemainingStorage returns the number of bytes the user may still upload,
+// taking the tighter of the user and team quotas
+func RemainingStorage(q Querier, userID string) (int64, error) {
+	usage, err := GetStorageUsage(q, userID)
+	if err != nil {
+		return 0, err
+	}
+	remaining := usage[0].Remaining
+	for _, u := range usage[1:] {
+		remaining = min(remaining, u.Remaining)
+	}
+	return remaining, nil
+}
+
+// ReserveStorage charges size bytes to the user and team inside tx, failing with
+// *QuotaExceededError if either quota would be exceeded. The usage rows stay locked
+// until tx ends, so concurrent uploads cannot both squeeze under the limit. It
+// returns the team subject charged ("team:<id>", or "" for none), which the file
+// row keeps for ReleaseStorage.
+func ReserveStorage(tx *sql.Tx, userID string, size int64) (string, error) {
+	subjects, err := quotaSubjects(tx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, s := range subjects {
+		if _, err := tx.Exec("INSERT INTO storage_usage (subject) VALUES ($1) ON CONFLICT (subject) DO NOTHING", s.key); err != nil {
+			return "", fmt.Errorf("failed to create usage row for %s: %v", s.key, err)
+		}
+		var used int64
+		if err := tx.QueryRow("SELECT bytes_used FROM storage_usage WHERE subject = $1 FOR UPDATE", s.key).Scan(&used); err != nil {
+			return "", fmt.Errorf("failed to lock usage row for %s: %v", s.key, err)
+		}
+		if used+size > s.quota {
+			return "", &QuotaExceededError{Scope: s.scope, Used: used, Quota: s.quota, Requested: size}
+		}
+	}
+
+	chargedTeam := ""
+	if len(subjects) > 1 {
+		chargedTeam = subjects[1].key
+	}
+	return chargedTeam, adjustUsage(tx, subjects, size)
+}
+
+// ReleaseStorage credits size bytes back to the user and to chargedTeam, the
+// team ReserveStorage charged when the file was stored ("" for none), inside tx
+func ReleaseStorage(tx *sql.Tx, userID, chargedTeam string, size int64) error {
+	subjects := []quotaSubject{{scope: "user", key: "user:" + userID}}
+	if chargedTeam != "" {
+		subjects = append(subjects, quotaSubject{scope: "team", key: chargedTeam})
+	}
+	return adjustUsage(tx, subjects, -size)
+}
+
+func adjustUsage(tx *sql.Tx, subjects []quotaSubject, delta int64) error {
+	for _, s := range subjects {
+		_, err := tx.Exec(`INSERT INTO storage_usage (subject, bytes_used, updated_at) VALUES ($1, GREATEST($2::BIGINT, 0), NOW())
+			ON CONFLICT (subject) DO UPDATE
+			SET bytes_used = GREATEST(storage_usage.bytes_used + $2::BIGINT, 0), updated_at = NOW()`, s.key, delta)
+		if err != nil {
+			return fmt.Errorf("failed to update usage for %s: %v", s.key, err)
+		}
+	}
+	return nil
+}
+
+// QuotaReader reads from R but fails with *QuotaExceededError once more than
+// Limit bytes have been read, so oversized uploads are cut off while streaming
+type QuotaReader struct {
+	R     io.Reader
+	Limit int64
+	N     int64
+}
+
+func (l *QuotaReader) Read(p []byte) (int, error) {
+	n, err := l.R.Read(p)
+	l.N += int64(n)
+	if l.N > l.Limit {
+		return n, &QuotaExceededError{Scope: "storage", Quota: l.Limit, Requested: l.N}
+	}
+	return n, err
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -42,3 +44,86 @@ func extractFileNameFromURL(fileURL string) string {
 	parts := strings.Split(fileURL, "/")
 	return parts[len(parts)-1] // Extract last part as file name
 }
+
+// S3KeyFromURL returns the object key of a file_url
+func S3KeyFromURL(fileURL string) string {
+	return extractFileNameFromURL(fileURL)
+}
+
+// S3Object is one entry of a bucket listing
+type S3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("AWS_REGION")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+// ListS3Objects lists every object in the bucket whose key starts with prefix
+func ListS3Objects(ctx context.Context, prefix string) ([]S3Object, error) {
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(os.Getenv("AWS_S3_BUCKET_NAME"))}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+
+	var objects []S3Object
+	pages := s3.NewListObjectsV2Paginator(client, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %v", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, S3Object{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// DeleteS3Key removes an object by key
+func DeleteS3Key(ctx context.Context, key string) error {
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(os.Getenv("AWS_S3_BUCKET_NAME")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from S3: %v", key, err)
+	}
+	return nil
+}
+
+// MoveS3Key copies an object to a new key and deletes the original
+func MoveS3Key(ctx context.Context, key, newKey string) error {
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	bucket := os.Getenv("AWS_S3_BUCKET_NAME")
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		CopySource: aws.String(bucket + "/" + url.PathEscape(key)),
+		Key:        aws.String(newKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", key, newKey, err)
+	}
+	return DeleteS3Key(ctx, key)
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrS3ObjectMissing is returned by OpenS3Object when S3 answers that the object
+// does not exist, as opposed to S3 being unreachable
+var ErrS3ObjectMissing = errors.New("object does not exist in S3")
+
+// StoredObject is an open handle on a file's content, from local disk or S3
+type StoredObject struct {
+	io.ReadCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// OpenStoredFile opens the local copy of a file, falling back to its S3 object
+// when the local copy is missing
+func OpenStoredFile(ctx context.Context, filePath, fileURL string) (*StoredObject, error) {
+	if filePath != "" {
+		if file, err := os.Open(filePath); err == nil {
+			stat, err := file.Stat()
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("failed to stat %s: %v", filePath, err)
+			}
+			return &StoredObject{ReadCloser: file, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+		}
+	}
+	if fileURL == "" {
+		return nil, fmt.Errorf("file %s is not available locally or in S3", filePath)
+	}
+	return OpenS3Object(ctx, fileURL)
+}
+
+// OpenS3Object opens the S3 object behind a file_url
+func OpenS3Object(ctx context.Context, fileURL string) (*StoredObject, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("AWS_REGION")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	out, err := s3.NewFromConfig(cfg).GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("AWS_S3_BUCKET_NAME")),
+		Key:    aws.String(extractFileNameFromURL(fileURL)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("failed to fetch %s from S3: %w", fileURL, ErrS3ObjectMissing)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from S3: %v", fileURL, err)
+	}
+	return &StoredObject{
+		ReadCloser: out.Body,
+		Size:       aws.ToInt64(out.ContentLength),
+		ModTime:    aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// NormalizeFolder cleans a user supplied folder path into "a/b" form, "" for the root
+func NormalizeFolder(folder string) string {
+	folder = strings.TrimSpace(strings.ReplaceAll(folder, "\\", "/"))
+	if folder == "" {
+		return ""
+	}
+	cleaned := strings.Trim(path.Clean("/"+folder), "/")
+	if cleaned == "." {
+		return ""
+	}
+	return cleaned
+}
@@ -0,0 +1,317 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxExtractedText caps the text kept per file for indexing, well below the
+// 1MB Postgres allows for a tsvector
+const MaxExtractedText = 512 << 10
+
+// maxExtractInput caps how much of a file is read while extracting text
+const maxExtractInput = 64 << 20
+
+// maxInflated caps the bytes decompressed from one document over all of its
+// streams or members, so many small compressed parts cannot each cost
+// maxExtractInput; tests lower it
+var maxInflated int64 = maxExtractInput
+
+// plainTextExts are indexed as they are
+var plainTextExts = map[string]bool{
+	".txt": true, ".md": true, ".csv": true, ".tsv": true, ".json": true, ".log": true,
+	".xml": true, ".yaml": true, ".yml": true, ".html": true, ".htm": true,
+}
+
+// CanExtractText reports whether ExtractText understands files with this name
+func CanExtractText(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return plainTextExts[ext] || ext == ".pdf" || ext == ".docx" || ext == ".pptx" || ext == ".xlsx"
+}
+
+// ExtractText returns the searchable text of a document, chosen by the
+// extension of name: plain text files, PDFs (text in uncompressed or
+// Flate-compressed content streams) and Office Open XML (docx, pptx, xlsx).
+// The result is valid UTF-8, at most MaxExtractedText bytes.
+func ExtractText(r io.Reader, name string) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxExtractInput))
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	switch ext := strings.ToLower(path.Ext(name)); {
+	case plainTextExts[ext]:
+		text = string(data)
+	case ext == ".pdf":
+		text = extractPDFText(data)
+	case ext == ".docx":
+		text, err = extractOfficeText(data, func(name string) bool { return name == "word/document.xml" }, "p")
+	case ext == ".pptx":
+		text, err = extractOfficeText(data, func(name string) bool {
+			return strings.HasPrefix(name, "ppt/slides/slide") && strings.HasSuffix(name, ".xml")
+		}, "p")
+	case ext == ".xlsx":
+		text, err = extractOfficeText(data, func(name string) bool { return name == "xl/sharedStrings.xml" }, "si")
+	default:
+		return "", fmt.Errorf("no text extractor for %s", ext)
+	}
+	if err != nil {
+		return "", err
+	}
+	return cleanText(text), nil
+}
+
+// cleanText makes text safe to store in Postgres and bounds its size
+func cleanText(text string) string {
+	text = strings.ToValidUTF8(text, " ")
+	text = strings.ReplaceAll(text, "\x00", " ")
+	if len(text) > MaxExtractedText {
+		text = text[:MaxExtractedText]
+		for !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// extractOfficeText collects the character data of every <t> element in the
+// zip members selected by want, starting a new line at each breakElem element
+func extractOfficeText(data []byte, want func(string) bool, breakElem string) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("not an Office document: %v", err)
+	}
+	var members []*zip.File
+	for _, f := range zr.File {
+		if want(f.Name) {
+			members = append(members, f)
+		}
+	}
+	// slide10 must follow slide9
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i].Name, members[j].Name
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+
+	var out strings.Builder
+	budget := maxInflated
+	for _, f := range members {
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		limited := &io.LimitedReader{R: rc, N: budget}
+		err = collectXMLText(limited, breakElem, &out)
+		rc.Close()
+		budget = limited.N
+		if err != nil && budget > 0 { // a member cut off by the budget keeps its text so far
+			return "", err
+		}
+		if out.Len() > MaxExtractedText || budget <= 0 {
+			break
+		}
+	}
+	return out.String(), nil
+}
+
+func collectXMLText(r io.Reader, breakElem string, out *strings.Builder) error {
+	dec := xml.NewDecoder(r)
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid document XML: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			inText = t.Name.Local == "t"
+		case xml.EndElement:
+			if t.Name.Local == "t" {
+				inText = false
+			}
+			if t.Name.Local == breakElem {
+				out.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				out.Write(t)
+			}
+		}
+	}
+}
+
+var (
+	pdfStreamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfTextBlock     = regexp.MustCompile(`(?s)\bBT\b(.*?)\bET\b`)
+	pdfNonContent    = regexp.MustCompile(`/(Length[123]|Subtype|Type\s*/(XObject|XRef|ObjStm|Metadata))\b`)
+)
+
+// extractPDFText pulls the literal strings shown by text operators out of a
+// PDF's content streams. Fonts with custom encodings come out garbled or
+// empty; that is acceptable for indexing.
+func extractPDFText(data []byte) string {
+	var out strings.Builder
+	budget := maxInflated
+	for _, loc := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[start : start+end]
+		if pdfNonContent.Match(dict) {
+			continue // embedded fonts, images and forms
+		}
+
+		var content []byte
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			if budget <= 0 {
+				return out.String()
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still yield what was decoded before the error
+			content, _ = io.ReadAll(io.LimitReader(zr, budget))
+			zr.Close()
+			budget -= int64(len(content))
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue // images and other encodings carry no text we can read
+		default:
+			content = raw
+		}
+
+		for _, block := range pdfTextBlock.FindAllSubmatch(content, -1) {
+			if text := pdfBlockText(block[1]); text != "" {
+				out.WriteString(text)
+				out.WriteByte('\n')
+			}
+		}
+		if out.Len() > MaxExtractedText {
+			break
+		}
+	}
+	return out.String()
+}
+
+// pdfKerningSpace is the TJ adjustment (thousandths of an em, negative moves
+// right) beyond which the gap is treated as a space between words
+const pdfKerningSpace = -180
+
+// pdfBlockText returns the text shown in one BT..ET block. Strings are joined
+// directly, since generators often split words across TJ elements for kerning;
+// a space is added for wide kerning gaps and for positioning operators. Blocks
+// that decode to mostly non-letters (fonts with custom encodings) are dropped.
+func pdfBlockText(block []byte) string {
+	var out []byte
+	space := func() {
+		if len(out) > 0 && out[len(out)-1] != ' ' {
+			out = append(out, ' ')
+		}
+	}
+	for i := 0; i < len(block); i++ {
+		c := block[i]
+		switch {
+		case c == '(':
+			i = readPDFString(block, i+1, &out)
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(block) && (block[j] == '.' || (block[j] >= '0' && block[j] <= '9')) {
+				j++
+			}
+			if n, err := strconv.ParseFloat(string(block[i:j]), 64); err == nil && n <= pdfKerningSpace {
+				space()
+			}
+			i = j - 1
+		case c == 'T' && i+1 < len(block) && (block[i+1] == 'd' || block[i+1] == 'D' || block[i+1] == 'm' || block[i+1] == '*'):
+			space()
+			i++
+		case c == '\'' || c == '"':
+			space()
+		}
+	}
+
+	text := strings.Join(strings.Fields(string(out)), " ")
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' {
+			letters++
+		}
+	}
+	if letters*10 < len(text)*8 {
+		return ""
+	}
+	return text
+}
+
+// readPDFString decodes the literal string starting after the "(" at start and
+// returns the index of its closing ")"
+func readPDFString(block []byte, start int, out *[]byte) int {
+	depth := 1
+	for i := start; i < len(block); i++ {
+		c := block[i]
+		switch c {
+		case '\\':
+			if i+1 >= len(block) {
+				return i
+			}
+			i++
+			switch e := block[i]; e {
+			case 'n', 'r', 't':
+				*out = append(*out, ' ')
+			case 'b', 'f', '\r', '\n':
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n, j := 0, 0
+				for ; j < 3 && i+j < len(block) && block[i+j] >= '0' && block[i+j] <= '7'; j++ {
+					n = n*8 + int(block[i+j]-'0')
+				}
+				i += j - 1
+				*out = append(*out, pdfByte(byte(n)))
+			default:
+				*out = append(*out, e)
+			}
+		case '(':
+			depth++
+			*out = append(*out, c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+			*out = append(*out, c)
+		default:
+			*out = append(*out, pdfByte(c))
+		}
+	}
+	return len(block)
+}
+
+// pdfByte maps a byte of a simple-font string to text; control and high bytes
+// depend on the font encoding, so they become spaces
+func pdfByte(c byte) byte {
+	if c < 32 || c >= 127 {
+		return ' '
+	}
+	return c
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfStream wraps content in a PDF stream object, Flate-compressed if deflate is set
+func pdfStream(t *testing.T, dict, content string, deflate bool) string {
+	t.Helper()
+	if deflate {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write([]byte(content))
+		zw.Close()
+		content = buf.String()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("1 0 obj\n<< /Length %d%s >>\nstream\n%s\nendstream\nendobj\n", len(content), dict, content)
+}
+
+func pdf(streams ...string) string {
+	return "%PDF-1.4\n" + strings.Join(streams, "") + "%%EOF\n"
+}
+
+// officeDoc builds a zip with the given members
+func officeDoc(t *testing.T, members map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range members {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func slide(text string) string {
+	return `<p:sld><a:p><a:r><a:t>` + text + `</a:t></a:r></a:p></p:sld>`
+}
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"plain text", "notes.txt", "  quarterly numbers \n", "quarterly numbers"},
+		{"invalid UTF-8 and NUL bytes", "data.csv", "a\x00b,\xffc", "a b, c"},
+		{"uppercase extension", "README.MD", "# Title", "# Title"},
+		{
+			"uncompressed PDF content",
+			"report.pdf",
+			pdf(pdfStream(t, "", "BT /F1 12 Tf (Hello) Tj 0 -14 Td (world) Tj ET", false)),
+			"Hello world",
+		},
+		{
+			"Flate-compressed PDF content with kerning",
+			"report.pdf",
+			pdf(pdfStream(t, "", "BT [(Gan)-20(tt) -400 (chart)] TJ ET", true)),
+			"Gantt chart",
+		},
+		{
+			"PDF octal escapes and nested parentheses",
+			"report.pdf",
+			pdf(pdfStream(t, "", `BT (caf\351 \(draft\)) Tj ET`, false)),
+			"caf (draft)",
+		},
+		{
+			"PDF fonts and images are skipped",
+			"report.pdf",
+			pdf(
+				pdfStream(t, " /Length1 100", "BT (font program) Tj ET", false),
+				pdfStream(t, " /Subtype /Image", "BT (pixels) Tj ET", false),
+				pdfStream(t, "", "BT (body) Tj ET", false)),
+			"body",
+		},
+		{
+			"docx paragraphs",
+			"letter.docx",
+			officeDoc(t, map[string]string{
+				"word/document.xml": `<w:document><w:body><w:p><w:r><w:t>Dear</w:t></w:r><w:r><w:t xml:space="preserve"> Sir</w:t></w:r></w:p><w:p><w:r><w:t>Regards</w:t></w:r></w:p></w:body></w:document>`,
+				"word/styles.xml":   `<w:styles><w:t>not body text</w:t></w:styles>`,
+			}),
+			"Dear Sir\nRegards",
+		},
+		{
+			"pptx slides in numeric order",
+			"deck.pptx",
+			officeDoc(t, map[string]string{
+				"ppt/slides/slide10.xml": slide("ten"),
+				"ppt/slides/slide9.xml":  slide("nine"),
+				"ppt/slides/slide1.xml":  slide("one"),
+			}),
+			"one\nnine\nten",
+		},
+		{
+			"xlsx shared strings",
+			"sheet.xlsx",
+			officeDoc(t, map[string]string{
+				"xl/sharedStrings.xml": `<sst><si><t>Revenue</t></si><si><r><t>Cost</t></r></si></sst>`,
+			}),
+			"Revenue\nCost",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractText(strings.NewReader(tt.content), tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("ExtractText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTextErrors(t *testing.T) {
+	for _, tt := range []struct{ file, content string }{
+		{"image.png", "\x89PNG"},
+		{"letter.docx", "not a zip"},
+		{"letter.docx", officeDoc(t, map[string]string{"word/document.xml": "<w:p><w:t>unclosed"})},
+	} {
+		if got, err := ExtractText(strings.NewReader(tt.content), tt.file); err == nil {
+			t.Errorf("ExtractText(%s) = %q, want an error", tt.file, got)
+		}
+	}
+}
+
+func TestExtractTextIsBounded(t *testing.T) {
+	got, err := ExtractText(strings.NewReader(strings.Repeat("é", MaxExtractedText)), "big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > MaxExtractedText || !strings.HasPrefix(got, "éé") || strings.HasSuffix(got, "\xc3") {
+		t.Fatalf("ExtractText kept %d bytes ending in %q", len(got), got[len(got)-2:])
+	}
+}
+
+// TestExtractTextInflationBudget checks that the decompression limit covers
+// the whole document: once earlier streams used it up, later ones are not
+// inflated at all
+func TestExtractTextInflationBudget(t *testing.T) {
+	defer func(n int64) { maxInflated = n }(maxInflated)
+	maxInflated = 4 << 10
+
+	padding := pdfStream(t, "", strings.Repeat(" ", 3<<10)+"BT (first) Tj ET", true)
+	doc := pdf(padding, padding, pdfStream(t, "", "BT (third) Tj ET", true))
+	got, err := ExtractText(strings.NewReader(doc), "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "third") {
+		t.Fatalf("ExtractText = %q: inflated past the document budget", got)
+	}
+
+	office := officeDoc(t, map[string]string{
+		"ppt/slides/slide1.xml": slide(strings.Repeat("a", 3<<10)),
+		"ppt/slides/slide2.xml": slide(strings.Repeat("b", 3<<10)),
+		"ppt/slides/slide3.xml": slide("third"),
+	})
+	got, err = ExtractText(strings.NewReader(office), "deck.pptx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "third") || !strings.HasPrefix(got, "aaa") {
+		t.Fatalf("ExtractText = %.20q...: want the text read within the budget", got)
+	}
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	_ "image/gif" // register GIF decoding for image.Decode
+)
+
+// ThumbnailSize is a named bounding box thumbnails are scaled to fit
+type ThumbnailSize struct {
+	Name string
+	Max  int
+}
+
+// ThumbnailSizes are generated for every image upload, smallest first
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "small", Max: 64},
+	{Name: "medium", Max: 256},
+	{Name: "large", Max: 1024},
+}
+
+// maxSourcePixels guards against decompression bombs
+const maxSourcePixels = 50_000_000
+
+// DecodeImage decodes a PNG, JPEG or GIF after checking its dimensions are sane.
+// It returns the decoded image and its format name.
+func DecodeImage(r io.ReadSeeker) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported image: %v", err)
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, "", fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %s image: %v", format, err)
+	}
+	return img, format, nil
+}
+
+// ResizeToFit scales img down so neither side exceeds maxDim, averaging the
+// source pixels each output pixel covers. Images that already fit are only copied.
+func ResizeToFit(img image.Image, maxDim int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= maxDim && sh <= maxDim {
+		return src
+	}
+	dw, dh := maxDim, sh*maxDim/sw
+	if sh > sw {
+		dw, dh = sw*maxDim/sh, maxDim
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			o := y*dst.Stride + x*4
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// EncodeThumbnail writes img as PNG when it may carry transparency (PNG/GIF sources)
+// and as JPEG otherwise. It returns the content type and file extension used.
+func EncodeThumbnail(w io.Writer, img image.Image, sourceFormat string) (string, string, error) {
+	if sourceFormat == "png" || sourceFormat == "gif" {
+		return "image/png", ".png", png.Encode(w, img)
+	}
+	return "image/jpeg", ".jpg", jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+)
+
+// UploadPolicy decides which kinds of file a user may upload. Empty allow lists
+// allow everything; deny lists win over allow lists. Types are media types,
+// optionally with a wildcard subtype ("image/*"); extensions include the dot.
+type UploadPolicy struct {
+	Subject         string     `json:"subject"` // "team:<id>", "role:<role>" or "default"
+	AllowTypes      []string   `json:"allow_types"`
+	DenyTypes       []string   `json:"deny_types"`
+	AllowExtensions []string   `json:"allow_extensions"`
+	DenyExtensions  []string   `json:"deny_extensions"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultPolicySubject is the stored policy used when neither the user's team
+// nor role has one
+const DefaultPolicySubject = "default"
+
+var policySubject = regexp.MustCompile(`^(default|role:[a-z0-9_-]+|team:[0-9]+)$`)
+
+// defaultDeniedTypes and defaultDeniedExtensions apply when no policy is stored
+// and UPLOAD_DENY_TYPES / UPLOAD_DENY_EXTENSIONS are unset
+var (
+	defaultDeniedTypes      = []string{TypeWindowsExecutable, TypeELFExecutable, TypeMachOExecutable, "application/x-msi"}
+	defaultDeniedExtensions = []string{".exe", ".dll", ".com", ".scr", ".msi", ".bat", ".cmd", ".ps1", ".vbs", ".jar"}
+)
+
+// UploadPolicyError is returned when a policy rejects an upload
+type UploadPolicyError struct {
+	Name   string
+	Reason string
+}
+
+func (e *UploadPolicyError) Error() string {
+	return fmt.Sprintf("%s cannot be uploaded: %s", e.Name, e.Reason)
+}
+
+// UploadPolicyValidationError is returned by SaveUploadPolicy for a malformed policy
+type UploadPolicyValidationError struct{ msg string }
+
+func (e *UploadPolicyValidationError) Error() string { return e.msg }
+
+// EnvUploadPolicy is the policy from UPLOAD_ALLOW_TYPES, UPLOAD_DENY_TYPES,
+// UPLOAD_ALLOW_EXTENSIONS and UPLOAD_DENY_EXTENSIONS (comma separated)
+func EnvUploadPolicy() *UploadPolicy {
+	p := &UploadPolicy{
+		Subject:         "env",
+		AllowTypes:      config.GetEnvList("UPLOAD_ALLOW_TYPES"),
+		DenyTypes:       config.GetEnvList("UPLOAD_DENY_TYPES"),
+		AllowExtensions: config.GetEnvList("UPLOAD_ALLOW_EXTENSIONS"),
+		DenyExtensions:  config.GetEnvList("UPLOAD_DENY_EXTENSIONS"),
+	}
+	if p.DenyTypes == nil {
+		p.DenyTypes = append([]string{}, defaultDeniedTypes...)
+	}
+	if p.DenyExtensions == nil {
+		p.DenyExtensions = append([]string{}, defaultDeniedExtensions...)
+	}
+	p.normalize()
+	return p
+}
+
+// LoadUploadPolicy returns the policy that applies to userID: their team's if
+// it has one, else their role's, else the stored default, else EnvUploadPolicy
+func LoadUploadPolicy(q Querier, userID string) (*UploadPolicy, error) {
+	var role string
+	var teamID sql.NullInt64
+	err := q.QueryRow("SELECT role, team_id FROM users WHERE email = $1", userID).Scan(&role, &teamID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load user %s: %v", userID, err)
+	}
+
+	candidates := []string{}
+	if teamID.Valid {
+		candidates = append(candidates, fmt.Sprintf("team:%d", teamID.Int64))
+	}
+	if role != "" {
+		candidates = append(candidates, "role:"+role)
+	}
+	candidates = append(candidates, DefaultPolicySubject)
+
+	policies, err := queryUploadPolicies(q, `WHERE subject = ANY($1::text[]) ORDER BY array_position($1::text[], subject::text) LIMIT 1`, candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return EnvUploadPolicy(), nil
+	}
+	return policies[0], nil
+}
+
+// ListUploadPolicies returns every stored policy
+func ListUploadPolicies(q Querier) ([]*UploadPolicy, error) {
+	return queryUploadPolicies(q, "ORDER BY subject")
+}
+
+// SaveUploadPolicy creates or replaces the policy for p.Subject
+func SaveUploadPolicy(q Querier, p *UploadPolicy) error {
+	if !policySubject.MatchString(p.Subject) {
+		return &UploadPolicyValidationError{fmt.Sprintf("subject must be %q, \"role:<role>\" or \"team:<id>\"", DefaultPolicySubject)}
+	}
+	for _, t := range append(append([]string{}, p.AllowTypes...), p.DenyTypes...) {
+		if major, minor, ok := strings.Cut(strings.TrimSpace(t), "/"); !ok || major == "" || minor == "" {
+			return &UploadPolicyValidationError{fmt.Sprintf("invalid media type %q", t)}
+		}
+	}
+	p.normalize()
+	return q.QueryRow(`INSERT INTO upload_policies (subject, allow_types, deny_types, allow_extensions, deny_extensions, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (subject) DO UPDATE
+		SET allow_types = EXCLUDED.allow_types, deny_types = EXCLUDED.deny_types,
+		    allow_extensions = EXCLUDED.allow_extensions, deny_extensions = EXCLUDED.deny_extensions, updated_at = NOW()
+		RETURNING updated_at`,
+		p.Subject, p.AllowTypes, p.DenyTypes, p.AllowExtensions, p.DenyExtensions).Scan(&p.UpdatedAt)
+}
+
+// DeleteUploadPolicy removes the stored policy for subject, reporting whether there was one
+func DeleteUploadPolicy(q Querier, subject string) (bool, error) {
+	res, err := q.Exec("DELETE FROM upload_policies WHERE subject = $1", subject)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func queryUploadPolicies(q Querier, clause string, args ...any) ([]*UploadPolicy, error) {
+	rows, err := q.Query(`SELECT subject, to_json(allow_types), to_json(deny_types),
+		to_json(allow_extensions), to_json(deny_extensions), updated_at
+		FROM upload_policies `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upload policies: %v", err)
+	}
+	defer rows.Close()
+
+	policies := []*UploadPolicy{}
+	for rows.Next() {
+		p := &UploadPolicy{}
+		var lists [4][]byte
+		if err := rows.Scan(&p.Subject, &lists[0], &lists[1], &lists[2], &lists[3], &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		for i, dst := range []*[]string{&p.AllowTypes, &p.DenyTypes, &p.AllowExtensions, &p.DenyExtensions} {
+			if err := json.Unmarshal(lists[i], dst); err != nil {
+				return nil, err
+			}
+		}
+		p.normalize()
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// Check returns an *UploadPolicyError if the policy rejects a file called name
+// whose content was detected as mediaType
+func (p *UploadPolicy) Check(name, mediaType string) error {
+	ext := strings.ToLower(path.Ext(name))
+	reject := func(reason string) error {
+		return &UploadPolicyError{Name: DisplayName(path.Base(name)), Reason: reason}
+	}
+	switch {
+	case matchesType(p.DenyTypes, mediaType):
+		return reject(mediaType + " files are not allowed")
+	case len(p.AllowTypes) > 0 && !matchesType(p.AllowTypes, mediaType):
+		return reject(mediaType + " files are not allowed")
+	case contains(p.DenyExtensions, ext):
+		return reject(fmt.Sprintf("%q files are not allowed", ext))
+	case len(p.AllowExtensions) > 0 && !contains(p.AllowExtensions, ext):
+		return reject(fmt.Sprintf("%q files are not allowed", ext))
+	}
+	return nil
+}
+
+// normalize lowercases every entry and gives extensions a leading dot
+func (p *UploadPolicy) normalize() {
+	for _, list := range []*[]string{&p.AllowTypes, &p.DenyTypes, &p.AllowExtensions, &p.DenyExtensions} {
+		if *list == nil {
+			*list = []string{}
+		}
+		for i, v := range *list {
+			(*list)[i] = strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	for _, list := range [][]string{p.AllowExtensions, p.DenyExtensions} {
+		for i, ext := range list {
+			if ext != "" && !strings.HasPrefix(ext, ".") {
+				list[i] = "." + ext
+			}
+		}
+	}
+}
+
+// matchesType reports whether mediaType is in patterns, where "image/*" covers
+// every image type and "*/*" everything
+func matchesType(patterns []string, mediaType string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, pattern := range patterns {
+		if pattern == mediaType || pattern == "*/*" || pattern == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUploadPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    UploadPolicy
+		file      string
+		mediaType string
+		wantErr   bool
+	}{
+		{"empty policy allows everything", UploadPolicy{}, "setup.exe", TypeWindowsExecutable, false},
+		{"allowed type", UploadPolicy{AllowTypes: []string{"image/png"}}, "photo.png", "image/png", false},
+		{"type outside the allow list", UploadPolicy{AllowTypes: []string{"image/png"}}, "report.pdf", typePDF, true},
+		{"wildcard subtype", UploadPolicy{AllowTypes: []string{"image/*"}}, "photo.jpg", "image/jpeg", false},
+		{"wildcard subtype does not cover other majors", UploadPolicy{AllowTypes: []string{"image/*"}}, "report.pdf", typePDF, true},
+		{"deny beats allow", UploadPolicy{AllowTypes: []string{"image/*"}, DenyTypes: []string{typeSVG}}, "logo.svg", typeSVG, true},
+		{"deny everything", UploadPolicy{DenyTypes: []string{"*/*"}}, "notes.txt", "text/plain", true},
+		{"type matching ignores case", UploadPolicy{DenyTypes: []string{"Image/SVG+XML"}}, "notes.txt", typeSVG, true},
+		{"denied extension", UploadPolicy{DenyExtensions: []string{".exe"}}, "setup.exe", "application/octet-stream", true},
+		{"extensions without a dot", UploadPolicy{DenyExtensions: []string{"EXE"}}, "Setup.EXE", "application/octet-stream", true},
+		{"allowed extension", UploadPolicy{AllowExtensions: []string{"pdf"}}, "report.PDF", typePDF, false},
+		{"extension outside the allow list", UploadPolicy{AllowExtensions: []string{"pdf"}}, "notes.txt", "text/plain", true},
+		{"no extension under an allow list", UploadPolicy{AllowExtensions: []string{"pdf"}}, "README", "text/plain", true},
+		{"denied type under an allowed extension", UploadPolicy{AllowExtensions: []string{"pdf"}, DenyTypes: []string{TypeWindowsExecutable}}, "report.pdf", TypeWindowsExecutable, true},
+		{"allowed type under a denied extension", UploadPolicy{AllowTypes: []string{"text/*"}, DenyExtensions: []string{"md"}}, "notes.md", "text/markdown", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.policy
+			p.normalize()
+			err := p.Check(tt.file, tt.mediaType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check(%q, %q) = %v, want error %v", tt.file, tt.mediaType, err, tt.wantErr)
+			}
+			var policyErr *UploadPolicyError
+			if err != nil && !errors.As(err, &policyErr) {
+				t.Fatalf("error %T is not an *UploadPolicyError", err)
+			}
+		})
+	}
+}
+
+func TestEnvUploadPolicy(t *testing.T) {
+	for _, name := range []string{"UPLOAD_ALLOW_TYPES", "UPLOAD_DENY_TYPES", "UPLOAD_ALLOW_EXTENSIONS", "UPLOAD_DENY_EXTENSIONS"} {
+		t.Setenv(name, "")
+	}
+	defaults := EnvUploadPolicy()
+	for _, tt := range []struct{ file, mediaType string }{
+		{"setup.exe", TypeWindowsExecutable},
+		{"tool", TypeELFExecutable},
+		{"run.bat", "text/plain"},
+		{"app.jar", typeZIP},
+	} {
+		if defaults.Check(tt.file, tt.mediaType) == nil {
+			t.Errorf("default policy accepted %s (%s)", tt.file, tt.mediaType)
+		}
+	}
+	if err := defaults.Check("report.pdf", typePDF); err != nil {
+		t.Errorf("default policy rejected a PDF: %v", err)
+	}
+
+	// Setting a list replaces its defaults
+	t.Setenv("UPLOAD_DENY_EXTENSIONS", " md , TXT ")
+	p := EnvUploadPolicy()
+	if err := p.Check("run.bat", "text/plain"); err != nil {
+		t.Errorf("run.bat rejected once UPLOAD_DENY_EXTENSIONS replaced the defaults: %v", err)
+	}
+	if p.Check("notes.txt", "text/plain") == nil {
+		t.Error("notes.txt accepted with UPLOAD_DENY_EXTENSIONS=md,TXT")
+	}
+	if p.Check("setup.exe", TypeWindowsExecutable) == nil {
+		t.Error("executable accepted: the default denied types still apply")
+	}
+}
+
+func TestUploadPolicyErrorUsesDisplayName(t *testing.T) {
+	p := UploadPolicy{DenyExtensions: []string{".exe"}}
+	p.normalize()
+	err := p.Check("1700000000_setup.exe", TypeWindowsExecutable)
+	if want := `setup.exe cannot be uploaded: ".exe" files are not allowed`; err == nil || err.Error() != want {
+		t.Fatalf("Check = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+)
+
+// allowPrivate reports whether receivers may live on loopback, private or
+// link-local addresses. It is off unless WEBHOOK_ALLOW_PRIVATE is set, so a
+// webhook cannot be pointed at the server itself or its internal network.
+func allowPrivate() bool {
+	return config.GetEnvBool("WEBHOOK_ALLOW_PRIVATE", false)
+}
+
+// checkAddr rejects addresses a webhook must not reach
+func checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return fmt.Errorf("url must not point at a private or local address")
+	}
+	return nil
+}
+
+// checkHost resolves host and rejects it if any of its addresses is blocked
+func checkHost(ctx context.Context, host string) error {
+	if allowPrivate() {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return checkAddr(addr)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("url host %q could not be resolved", host)
+	}
+	for _, addr := range addrs {
+		if err := checkAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// guardedDialer checks the address actually dialed, after DNS resolution, so a
+// host that resolved to a public address at registration cannot later be
+// rebound to an internal one. Redirects are dialed through it too.
+func guardedDialer() *net.Dialer {
+	return &net.Dialer{
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate() {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			return checkAddr(addrPort.Addr())
+		},
+	}
+}
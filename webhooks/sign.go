@@ -0,0 +1,124 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"     // event type, e.g. "file.uploaded"
+	HeaderEventID   = "X-Webhook-ID"        // event id, the same on every redelivery
+	HeaderDelivery  = "X-Webhook-Delivery"  // delivery row id
+	HeaderTimestamp = "X-Webhook-Timestamp" // unix seconds, covered by the signature
+	HeaderSignature = "X-Webhook-Signature" // "v1=" + hex HMAC-SHA256 of "<timestamp>.<body>"
+)
+
+// maxResponseBody caps how much of a receiver's response is kept in the delivery log
+const maxResponseBody = 1024
+
+// NewSecret returns a random signing secret for a new webhook
+func NewSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign returns the signature header value for body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery the way receivers should: the signature must match and
+// the timestamp must be within tolerance of now, so captured requests cannot be replayed
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp outside tolerance")
+	}
+	expected := Sign(secret, ts, body)
+	for _, candidate := range strings.Split(signature, ",") {
+		if hmac.Equal([]byte(strings.TrimSpace(candidate)), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
+
+// Sender posts signed deliveries. Client is injectable so tests can point it at
+// an httptest receiver.
+type Sender struct {
+	Client *http.Client
+	Now    func() time.Time
+}
+
+// NewSender returns a Sender using client, or a client with WEBHOOK_TIMEOUT
+// (default 10s) that refuses to connect to private and local addresses
+func NewSender(client *http.Client) *Sender {
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil // the dialer must see the receiver's address, not a proxy's
+		transport.DialContext = guardedDialer().DialContext
+		client = &http.Client{Transport: transport, Timeout: config.GetEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)}
+	}
+	return &Sender{Client: client, Now: time.Now}
+}
+
+// Result is the outcome of one delivery attempt
+type Result struct {
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+// OK reports whether the receiver accepted the delivery with a 2xx response
+func (r Result) OK() bool {
+	return r.Err == nil && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Send posts one delivery body to url, signed with secret
+func (s *Sender) Send(ctx context.Context, url, secret string, d *Delivery) Result {
+	ts := s.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return Result{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "file-sharing-platform-webhooks/1")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderEventID, d.EventID)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, ts, d.Payload))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // let the connection be reused
+	result := Result{StatusCode: resp.StatusCode, Body: string(body)}
+	if !result.OK() {
+		result.Err = fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return result
+}
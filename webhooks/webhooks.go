@@ -0,0 +1,375 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/queue"
+)
+
+// Event types a webhook can subscribe to
+const (
+	EventFileUploaded   = "file.uploaded"
+	EventFileShared     = "file.shared"
+	EventFileDownloaded = "file.downloaded"
+	EventFileExpired    = "file.expired"
+)
+
+// EventTypes lists every event a webhook can filter on; "*" matches all of them
+var EventTypes = []string{EventFileUploaded, EventFileShared, EventFileDownloaded, EventFileExpired}
+
+// Delivery states
+const (
+	StatusPending   = "pending"
+	StatusRetrying  = "retrying"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned when a webhook or delivery does not exist or belongs to someone else
+var ErrNotFound = errors.New("webhook not found")
+
+// Webhook is an endpoint registered by a user
+type Webhook struct {
+	ID          int       `json:"id"`
+	Owner       string    `json:"-"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"` // only returned when the webhook is created
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Delivery is one event sent, or to be sent, to a webhook
+type Delivery struct {
+	ID            int             `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  *int            `json:"response_code,omitempty"`
+	ResponseBody  *string         `json:"response_body,omitempty"`
+	Error         *string         `json:"error,omitempty"`
+	RedeliveryOf  *int            `json:"redelivery_of,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Body is the JSON document posted to receivers
+type Body struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// FileData describes the file an event is about
+type FileData struct {
+	FileID   int    `json:"file_id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Actor    string `json:"actor,omitempty"` // user who caused the event when it is not the owner
+}
+
+var db *sql.DB
+var jobQueue *queue.Queue
+
+// Init lets Dispatch record deliveries in db and queue them on q
+func Init(database *sql.DB, q *queue.Queue) {
+	db, jobQueue = database, q
+}
+
+// ValidateURL accepts absolute http and https URLs whose host does not resolve
+// to a loopback, private, link-local or unspecified address
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	return checkHost(ctx, u.Hostname())
+}
+
+// ValidateEvents checks an event filter, defaulting an empty one to every event
+func ValidateEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return []string{"*"}, nil
+	}
+	for _, event := range events {
+		known := event == "*"
+		for _, t := range EventTypes {
+			known = known || t == event
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return events, nil
+}
+
+// Create registers a webhook with a fresh secret
+func Create(ctx context.Context, database *sql.DB, owner, rawURL, description string, events []string) (*Webhook, error) {
+	hook := &Webhook{Owner: owner, URL: rawURL, Secret: NewSecret(), Events: events, Description: description, Active: true}
+	err := database.QueryRowContext(ctx, `
+		INSERT INTO webhooks (owner_id, url, secret, events, description)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		owner, rawURL, hook.Secret, events, description).Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create webhook: %v", err)
+	}
+	return hook, nil
+}
+
+// List returns the owner's webhooks without their secrets
+func List(ctx context.Context, database *sql.DB, owner string) ([]Webhook, error) {
+	rows, err := database.QueryContext(ctx, `
+		SELECT id, url, to_json(events), description, active, created_at
+		FROM webhooks WHERE owner_id = $1 ORDER BY id`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		hook := Webhook{Owner: owner}
+		var events []byte
+		if err := rows.Scan(&hook.ID, &hook.URL, &events, &hook.Description, &hook.Active, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(events, &hook.Events)
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// Delete removes one of the owner's webhooks and its delivery log
+func Delete(ctx context.Context, database *sql.DB, owner string, id int) error {
+	res, err := database.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND owner_id = $2", id, owner)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts,
+	response_code, response_body, error, redelivery_of, created_at, last_attempt_at, delivered_at`
+
+func scanDelivery(row interface{ Scan(...any) error }) (*Delivery, error) {
+	d := &Delivery{}
+	var payload []byte
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.ResponseCode, &d.ResponseBody, &d.Error, &d.RedeliveryOf, &d.CreatedAt, &d.LastAttemptAt, &d.DeliveredAt)
+	d.Payload = payload
+	return d, err
+}
+
+// Deliveries returns the newest deliveries of one of the owner's webhooks
+func Deliveries(ctx context.Context, database *sql.DB, owner string, webhookID, limit int) ([]*Delivery, error) {
+	var exists bool
+	err := database.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND owner_id = $2)",
+		webhookID, owner).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := database.QueryContext(ctx, "SELECT "+deliveryColumns+`
+		FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Dispatch records a delivery of the event for each of the owner's active webhooks
+// that subscribe to it and queues them. It is a no-op until Init is called.
+func Dispatch(ctx context.Context, owner, eventType string, data any) error {
+	if db == nil || jobQueue == nil || owner == "" {
+		return nil
+	}
+	eventID := newEventID()
+	body, err := json.Marshal(Body{ID: eventID, Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $3, $2, $4::jsonb FROM webhooks
+		WHERE owner_id = $1 AND active AND ($2 = ANY(events) OR '*' = ANY(events))
+		RETURNING id`, owner, eventType, eventID, string(body))
+	if err != nil {
+		return fmt.Errorf("record webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := enqueue(ctx, owner, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redeliver sends an earlier delivery again as a new delivery row with the same
+// event id and body, so the log keeps every attempt
+func Redeliver(ctx context.Context, database *sql.DB, owner string, webhookID, deliveryID int) (*Delivery, error) {
+	if jobQueue == nil {
+		return nil, fmt.Errorf("webhook delivery is not configured")
+	}
+	row := database.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, redelivery_of)
+		SELECT d.webhook_id, d.event_id, d.event_type, d.payload, d.id
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $1 AND d.webhook_id = $2 AND w.owner_id = $3
+		RETURNING `+deliveryColumns, deliveryID, webhookID, owner)
+	d, err := scanDelivery(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, enqueue(ctx, owner, d.ID)
+}
+
+func enqueue(ctx context.Context, owner string, deliveryID int) error {
+	_, err := jobQueue.Enqueue(ctx, queue.TypeDeliverWebhook,
+		queue.DeliverWebhookPayload{DeliveryID: deliveryID}, queue.Meta{Owner: owner})
+	if err != nil {
+		return fmt.Errorf("queue webhook delivery %d: %v", deliveryID, err)
+	}
+	return nil
+}
+
+// DeliverJob attempts one delivery. Failed attempts return an error so the queue
+// retries them with exponential backoff; every attempt is recorded in the log.
+func DeliverJob(database *sql.DB, sender *Sender) queue.HandlerFunc {
+	return func(ctx context.Context, job *queue.Job) error {
+		payload, err := queue.Decode[queue.DeliverWebhookPayload](job)
+		if err != nil {
+			return err
+		}
+
+		var hookURL, secret string
+		var active bool
+		var body []byte
+		d := &Delivery{}
+		err = database.QueryRowContext(ctx, `
+			SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+			       w.url, w.secret, w.active
+			FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.id = $1`, payload.DeliveryID).
+			Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &body, &d.Status, &d.Attempts, &hookURL, &secret, &active)
+		if err == sql.ErrNoRows {
+			return nil // the webhook was deleted along with its deliveries
+		}
+		if err != nil {
+			return err
+		}
+		d.Payload = body
+		if d.Status == StatusSucceeded {
+			return nil
+		}
+		if !active {
+			recordAttempt(ctx, database, d.ID, StatusFailed, Result{Err: fmt.Errorf("webhook is disabled")}, false)
+			return nil
+		}
+
+		result := sender.Send(ctx, hookURL, secret, d)
+		if result.OK() {
+			recordAttempt(ctx, database, d.ID, StatusSucceeded, result, true)
+			return nil
+		}
+		recordAttempt(ctx, database, d.ID, StatusRetrying, result, true)
+		return result.Err
+	}
+}
+
+// DeliveryFailed marks a delivery failed once the queue has given up on it
+func DeliveryFailed(database *sql.DB) queue.DeadHandlerFunc {
+	return func(ctx context.Context, job *queue.Job, err error) {
+		payload, decodeErr := queue.Decode[queue.DeliverWebhookPayload](job)
+		if decodeErr != nil {
+			return
+		}
+		_, dbErr := database.ExecContext(ctx,
+			"UPDATE webhook_deliveries SET status = $2 WHERE id = $1 AND status <> $3",
+			payload.DeliveryID, StatusFailed, StatusSucceeded)
+		if dbErr != nil {
+			log.Printf(" Failed to mark webhook delivery %d failed: %v\n", payload.DeliveryID, dbErr)
+		}
+	}
+}
+
+// recordAttempt stores the outcome of an attempt; attempted is false when
+// nothing was sent
+func recordAttempt(ctx context.Context, database *sql.DB, deliveryID int, status string, result Result, attempted bool) {
+	var code *int
+	if result.StatusCode != 0 {
+		code = &result.StatusCode
+	}
+	var errMsg *string
+	if result.Err != nil {
+		msg := result.Err.Error()
+		errMsg = &msg
+	}
+	increment := 0
+	if attempted {
+		increment = 1
+	}
+	_, err := database.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + $3, response_code = $4, response_body = $5, error = $6,
+		    last_attempt_at = NOW(),
+		    delivered_at = CASE WHEN $7 THEN NOW() ELSE delivered_at END
+		WHERE id = $1`, deliveryID, status, increment, code, result.Body, errMsg, status == StatusSucceeded)
+	if err != nil {
+		log.Printf(" Failed to record webhook delivery %d: %v\n", deliveryID, err)
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,195 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SOMAK939/file-sharing-platform/queue"
+)
+
+const testSecret = "whsec_test"
+
+// receiver is an httptest endpoint that verifies every delivery's signature
+// and answers with the next status in statuses
+type receiver struct {
+	t        *testing.T
+	statuses []int
+	calls    atomic.Int32
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := Verify(testSecret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Minute); err != nil {
+		rc.t.Errorf("delivery signature: %v", err)
+	}
+	if got := r.Header.Get(HeaderEvent); got != EventFileUploaded {
+		rc.t.Errorf("%s = %q, want %q", HeaderEvent, got, EventFileUploaded)
+	}
+	if got := r.Header.Get(HeaderDelivery); got != "7" {
+		rc.t.Errorf("%s = %q, want 7", HeaderDelivery, got)
+	}
+	n := int(rc.calls.Add(1)) - 1
+	w.WriteHeader(rc.statuses[min(n, len(rc.statuses)-1)])
+	io.WriteString(w, "ack")
+}
+
+func deliveryJob(t *testing.T, id int) *queue.Job {
+	payload, err := json.Marshal(queue.DeliverWebhookPayload{DeliveryID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &queue.Job{ID: "job-1", Type: queue.TypeDeliverWebhook, Payload: payload}
+}
+
+func expectDeliveryLookup(mock sqlmock.Sqlmock, url, status string, attempts int) {
+	mock.ExpectQuery("FROM webhook_deliveries d JOIN webhooks w").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "event_id", "event_type", "payload",
+			"status", "attempts", "url", "secret", "active"}).
+			AddRow(7, 3, "evt_1", EventFileUploaded, []byte(`{"id":"evt_1","type":"file.uploaded"}`),
+				status, attempts, url, testSecret, true))
+}
+
+func expectAttempt(mock sqlmock.Sqlmock, status string, code int, errMsg any) {
+	mock.ExpectExec("UPDATE webhook_deliveries").
+		WithArgs(7, status, 1, code, "ack", errMsg, status == StatusSucceeded).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Now().Unix()
+	sig := Sign(testSecret, now, body)
+	ts := strconv.FormatInt(now, 10)
+
+	if err := Verify(testSecret, ts, sig, body, time.Minute); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := Verify(testSecret, ts, "v1=00,"+sig, body, time.Minute); err != nil {
+		t.Fatalf("signature list rejected: %v", err)
+	}
+	if err := Verify("whsec_other", ts, sig, body, time.Minute); err == nil {
+		t.Fatal("signature accepted with the wrong secret")
+	}
+	if err := Verify(testSecret, ts, sig, []byte(`{"id":"evt_2"}`), time.Minute); err == nil {
+		t.Fatal("signature accepted for a different body")
+	}
+	old := strconv.FormatInt(now-600, 10)
+	if err := Verify(testSecret, old, Sign(testSecret, now-600, body), body, time.Minute); err == nil {
+		t.Fatal("stale timestamp accepted")
+	}
+}
+
+// TestDeliverJobRetries sends a delivery to a receiver that fails once: the
+// first attempt is logged as retrying and handed back to the queue, the retry
+// is logged as succeeded
+func TestDeliverJobRetries(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	database, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	handler := DeliverJob(database, NewSender(srv.Client()))
+	job := deliveryJob(t, 7)
+
+	expectDeliveryLookup(mock, srv.URL, StatusPending, 0)
+	expectAttempt(mock, StatusRetrying, http.StatusInternalServerError, "receiver responded 500 Internal Server Error")
+	if err := handler(context.Background(), job); err == nil {
+		t.Fatal("failed attempt did not return an error for the queue to retry")
+	}
+
+	expectDeliveryLookup(mock, srv.URL, StatusRetrying, 1)
+	expectAttempt(mock, StatusSucceeded, http.StatusOK, nil)
+	if err := handler(context.Background(), job); err != nil {
+		t.Fatalf("successful attempt returned %v", err)
+	}
+
+	// Once delivered, a duplicate job is a no-op
+	expectDeliveryLookup(mock, srv.URL, StatusSucceeded, 2)
+	if err := handler(context.Background(), job); err != nil {
+		t.Fatalf("duplicate job returned %v", err)
+	}
+
+	if got := rc.calls.Load(); got != 2 {
+		t.Fatalf("receiver called %d times, want 2", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliveryFailedMarksLog(t *testing.T) {
+	database, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	mock.ExpectExec("UPDATE webhook_deliveries SET status").
+		WithArgs(7, StatusFailed, StatusSucceeded).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	DeliveryFailed(database)(context.Background(), deliveryJob(t, 7), sql.ErrConnDone)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateURLRejectsPrivateAddresses(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "")
+	for _, raw := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"ftp://example.com/hook",
+	} {
+		if err := ValidateURL(context.Background(), raw); err == nil {
+			t.Errorf("ValidateURL(%q) accepted", raw)
+		}
+	}
+	if err := ValidateURL(context.Background(), "https://93.184.215.14/hook"); err != nil {
+		t.Errorf("public address rejected: %v", err)
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+	if err := ValidateURL(context.Background(), "http://127.0.0.1/hook"); err != nil {
+		t.Errorf("loopback rejected with WEBHOOK_ALLOW_PRIVATE: %v", err)
+	}
+}
+
+// TestSenderRefusesPrivateAddresses checks the dial-time guard, which is what
+// stops a host from being rebound to an internal address after registration
+func TestSenderRefusesPrivateAddresses(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "")
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	result := NewSender(nil).Send(context.Background(), srv.URL, testSecret, &Delivery{ID: 1, Payload: []byte("{}")})
+	if result.Err == nil || result.OK() {
+		t.Fatalf("delivery to %s was not refused", srv.URL)
+	}
+	if calls.Load() != 0 {
+		t.Fatal("receiver on a loopback address was reached")
+	}
+}
@@ -0,0 +1,138 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/lock"
+)
+
+// ErrCleanupRunning is returned by RunCleanup when another instance holds the cleanup lock
+var ErrCleanupRunning = errors.New("cleanup is already running on another instance")
+
+// CleanupRun is the statistics of one cleanup pass. In a dry run Scanned and
+// BytesFreed describe what would have been deleted.
+type CleanupRun struct {
+	ID         int        `json:"id"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DryRun     bool       `json:"dry_run"`
+	Holder     string     `json:"holder"`
+	Fence      int64      `json:"fence"`
+	Scanned    int        `json:"scanned"`
+	Deleted    int        `json:"deleted"`
+	Failed     int        `json:"failed"`
+	BytesFreed int64      `json:"bytes_freed"`
+	Error      *string    `json:"error,omitempty"`
+}
+
+// startRun records the start of a pass
+func startRun(ctx context.Context, db *sql.DB, fence int64, dryRun bool) (*CleanupRun, error) {
+	run := &CleanupRun{DryRun: dryRun, Holder: lock.Holder, Fence: fence}
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO cleanup_runs (dry_run, holder, fence) VALUES ($1, $2, $3)
+		RETURNING id, started_at`, dryRun, run.Holder, fence).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// saveRun stores the counters so far, so a long run shows progress
+func saveRun(db *sql.DB, run *CleanupRun) {
+	_, err := db.Exec(`UPDATE cleanup_runs SET scanned = $2, deleted = $3, failed = $4, bytes_freed = $5 WHERE id = $1`,
+		run.ID, run.Scanned, run.Deleted, run.Failed, run.BytesFreed)
+	if err != nil {
+		log.Printf(" Failed to save cleanup run %d: %v\n", run.ID, err)
+	}
+}
+
+// finishRun stores the final counters and the error that ended the run, if any
+func finishRun(db *sql.DB, run *CleanupRun, runErr error) {
+	now := time.Now()
+	run.FinishedAt = &now
+	if runErr != nil {
+		msg := runErr.Error()
+		run.Error = &msg
+	}
+	_, err := db.Exec(`UPDATE cleanup_runs
+		SET scanned = $2, deleted = $3, failed = $4, bytes_freed = $5, finished_at = NOW(), error = $6
+		WHERE id = $1`, run.ID, run.Scanned, run.Deleted, run.Failed, run.BytesFreed, run.Error)
+	if err != nil {
+		log.Printf(" Failed to save cleanup run %d: %v\n", run.ID, err)
+	}
+}
+
+// ListCleanupRuns returns the most recent cleanup passes, newest first
+func ListCleanupRuns(ctx context.Context, db *sql.DB, limit int) ([]CleanupRun, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, started_at, finished_at, dry_run, COALESCE(holder, ''), COALESCE(fence, 0),
+		       scanned, deleted, failed, bytes_freed, error
+		FROM cleanup_runs ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []CleanupRun{}
+	for rows.Next() {
+		var run CleanupRun
+		err := rows.Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.DryRun, &run.Holder, &run.Fence,
+			&run.Scanned, &run.Deleted, &run.Failed, &run.BytesFreed, &run.Error)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// StuckDeletion is a file cleanup gave up on after CLEANUP_MAX_ATTEMPTS failed
+// attempts to delete its storage. It stays 'deleting' until an operator retries it.
+type StuckDeletion struct {
+	FileID     int       `json:"file_id"`
+	Filename   string    `json:"filename"`
+	OwnerID    string    `json:"owner_id"`
+	Size       int64     `json:"size"`
+	Attempts   int       `json:"attempts"`
+	Error      *string   `json:"error,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// ListStuckDeletions returns files cleanup no longer retries, oldest first
+func ListStuckDeletions(ctx context.Context, db *sql.DB, limit int) ([]StuckDeletion, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, filename, COALESCE(owner_id, ''), COALESCE(size, 0), delete_attempts, delete_error, uploaded_at
+		FROM files WHERE status = 'deleting' AND delete_attempts >= $1
+		ORDER BY id LIMIT $2`, cleanupMaxAttempts(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stuck := []StuckDeletion{}
+	for rows.Next() {
+		var s StuckDeletion
+		if err := rows.Scan(&s.FileID, &s.Filename, &s.OwnerID, &s.Size, &s.Attempts, &s.Error, &s.UploadedAt); err != nil {
+			return nil, err
+		}
+		stuck = append(stuck, s)
+	}
+	return stuck, rows.Err()
+}
+
+// RetryStuckDeletion resets a stuck file's attempt count so the next cleanup
+// pass tries to delete it again. It reports false if the file is not stuck.
+func RetryStuckDeletion(ctx context.Context, db *sql.DB, fileID int) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE files SET delete_attempts = 0
+		WHERE id = $1 AND status = 'deleting' AND delete_attempts >= $2`, fileID, cleanupMaxAttempts())
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -1,76 +1,384 @@
 package workers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"sort"
 	"time"
 
+	"github.com/SOMAK939/file-sharing-platform/cache"
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/lock"
+	"github.com/SOMAK939/file-sharing-platform/notify"
 	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/SOMAK939/file-sharing-platform/webhooks"
+	"github.com/redis/go-redis/v9"
 )
 
-// StartFileCleanupWorker runs a background job for expired file deletion
-func StartFileCleanupWorker(db *sql.DB) {
+// fileTTL is how long a file lives after upload, and cleanupInterval how often expired files are removed
+const (
+	fileTTL         = 1 * time.Hour
+	cleanupInterval = 1 * time.Hour
+)
+
+// CleanupLockName is the distributed lock that elects the replica running a cleanup pass
+const CleanupLockName = "cleanup-worker"
+
+// cleanupLockTTL is how long a lease lasts without renewal; a crashed holder
+// blocks cleanup for at most this long
+func cleanupLockTTL() time.Duration {
+	return config.GetEnvDuration("CLEANUP_LOCK_TTL", time.Minute)
+}
+
+// expiryWarningWindow is how long before its expiry an owner is warned about a
+// file, and expiryCheckInterval how often that is checked. The check interval
+// must be shorter than the window or some files expire unannounced.
+func expiryWarningWindow() time.Duration {
+	return config.GetEnvDuration("EXPIRY_WARNING_WINDOW", 10*time.Minute)
+}
+
+func expiryCheckInterval() time.Duration {
+	return min(config.GetEnvDuration("EXPIRY_CHECK_INTERVAL", 5*time.Minute), expiryWarningWindow()/2)
+}
+
+// cleanupBatchSize is how many expired rows one batch claims
+func cleanupBatchSize() int {
+	return int(config.GetEnvInt64("CLEANUP_BATCH_SIZE", 100))
+}
+
+// cleanupMaxAttempts is how many runs may fail to delete a file's storage before
+// cleanup stops retrying it and lists it under /admin/cleanup/stuck for an operator
+func cleanupMaxAttempts() int {
+	return int(config.GetEnvInt64("CLEANUP_MAX_ATTEMPTS", 10))
+}
+
+// StartFileCleanupWorker runs a background job for expired file deletion. Every
+// replica runs the ticker, but each pass first takes a Redis lease lock so only
+// one of them does the work. CLEANUP_DRY_RUN=true only reports what would be deleted.
+func StartFileCleanupWorker(db *sql.DB, rdb *redis.Client) {
 	fmt.Println(" Starting Background Cleanup Worker...") // ADD THIS
-	ticker := time.NewTicker(1 * time.Hour) // Runs every 1 hour
+	ticker := time.NewTicker(cleanupInterval)
 	go func() {
 		for range ticker.C {
-			log.Println(" Running file cleanup job...")
-			err := deleteExpiredFiles(db)
+			run, err := RunCleanup(context.Background(), db, rdb, config.GetEnvBool("CLEANUP_DRY_RUN", false))
+			if err == ErrCleanupRunning {
+				log.Println(" Skipping file cleanup, another instance holds the lock")
+				continue
+			}
 			if err != nil {
 				log.Println(" File cleanup job failed:", err)
 			}
-			
+			if run != nil {
+				log.Printf(" Cleanup run %d: scanned %d, deleted %d, failed %d, freed %d bytes\n",
+					run.ID, run.Scanned, run.Deleted, run.Failed, run.BytesFreed)
+			}
+		}
+	}()
+
+	// Expiry warnings need a finer grain than cleanup passes. Each file is
+	// claimed by a single UPDATE, so replicas can all check without the lock.
+	warnTicker := time.NewTicker(expiryCheckInterval())
+	go func() {
+		for range warnTicker.C {
+			if err := notifyExpiringFiles(context.Background(), db); err != nil {
+				log.Println(" Expiry notification failed:", err)
+			}
 		}
 	}()
 }
 
-// deleteExpiredFiles finds and removes expired files
-func deleteExpiredFiles(db *sql.DB) error {
-	expirationThreshold := time.Now().Add(-1*time.Hour) // Files older than 1 Hour
+// RunCleanup performs one cleanup pass under the cleanup lock and records its
+// statistics. It returns ErrCleanupRunning if another instance holds the lock.
+func RunCleanup(ctx context.Context, db *sql.DB, rdb *redis.Client, dryRun bool) (*CleanupRun, error) {
+	var run *CleanupRun
+	ran, err := lock.Run(ctx, rdb, CleanupLockName, cleanupLockTTL(), func(ctx context.Context, fence int64) error {
+		var err error
+		run, err = startRun(ctx, db, fence, dryRun)
+		if err != nil {
+			return err
+		}
+		log.Printf(" Running file cleanup job %d (fence %d, dry run %v)...\n", run.ID, fence, dryRun)
 
-	rows, err := db.Query("SELECT id, COALESCE(file_url, '') FROM files WHERE uploaded_at < $1", expirationThreshold)
+		if dryRun {
+			err = previewExpiredFiles(ctx, db, run)
+		} else {
+			err = deleteExpiredFiles(ctx, db, rdb, fence, run)
+			if notifyErr := notifyExpiringFiles(ctx, db); notifyErr != nil {
+				log.Println(" Expiry notification failed:", notifyErr)
+			}
+		}
+		finishRun(db, run, err)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("error fetching expired files: %v", err)
+		return run, err
 	}
-	defer rows.Close()
+	if !ran {
+		return nil, ErrCleanupRunning
+	}
+	return run, nil
+}
 
-	var expiredFiles []struct {
-		ID      int
-		FileURL string
+// notifyExpiringFiles warns owners about files that will expire within
+// expiryWarningWindow. Each file is only announced once.
+func notifyExpiringFiles(ctx context.Context, db *sql.DB) error {
+	threshold := time.Now().Add(expiryWarningWindow() - fileTTL)
+	rows, err := db.QueryContext(ctx, `UPDATE files SET expiry_notified_at = NOW()
+		WHERE expiry_notified_at IS NULL AND status = 'active' AND uploaded_at < $1 AND owner_id IS NOT NULL
+		RETURNING id, filename, owner_id, uploaded_at`, threshold)
+	if err != nil {
+		return fmt.Errorf("error fetching expiring files: %v", err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var file struct {
-			ID      int
-			FileURL string
-		}
-		if err := rows.Scan(&file.ID, &file.FileURL); err != nil {
-			log.Printf("Skipping file due to scan error: %v\n", err)
-			continue // Continue processing other rows instead of stopping
+		var id int
+		var filename, ownerID string
+		var uploadedAt time.Time
+		if err := rows.Scan(&id, &filename, &ownerID, &uploadedAt); err != nil {
+			return err
 		}
-		expiredFiles = append(expiredFiles, file)
+		notify.Publish(ownerID, notify.NewEvent(notify.TypeFileExpiringSoon, notify.FileExpiringPayload{
+			FilePayload: notify.FilePayload{FileID: id, Filename: filename},
+			ExpiresAt:   uploadedAt.Add(fileTTL).UTC(),
+		}))
 	}
+	return rows.Err()
+}
 
-	for _, file := range expiredFiles {
-		if file.FileURL == "" {
-			log.Printf(" Skipping file ID %d because it has an empty file_url\n", file.ID)
-			continue
-		}
+// expiredFile is a row claimed for deletion
+type expiredFile struct {
+	ID       int
+	Filename string
+	FilePath string
+	FileURL  string
+	OwnerID  string
+	Size     int64
+	Team     string // quota subject charged at upload, "" for none
+}
 
-		err := utils.DeleteFromS3(file.FileURL)
+// expiredCondition selects rows cleanup should (re)try: files past their TTL,
+// including ones still held for a scan or quarantined, and files a previous run
+// marked 'deleting' but could not finish
+const expiredCondition = `((status IN ('active', 'pending_scan', 'quarantined') AND uploaded_at < $1) OR (status = 'deleting' AND delete_attempts < $2))`
+
+// deleteExpiredFiles removes expired files in batches of cleanupBatchSize. Each
+// batch is first marked 'deleting' in one transaction, then its storage is
+// removed and each row deleted in its own transaction. Rows whose storage could
+// not be removed stay 'deleting' and are retried by the next run. It stops when
+// ctx is cancelled, which happens if the cleanup lease is lost.
+func deleteExpiredFiles(ctx context.Context, db *sql.DB, rdb *redis.Client, fence int64, run *CleanupRun) error {
+	threshold := time.Now().Add(-fileTTL)
+	afterID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("cleanup stopped: %v", err)
+		}
+		batch, err := claimBatch(ctx, db, fence, threshold, afterID)
 		if err != nil {
-			log.Printf(" Failed to delete file from S3 (%s): %v\n", file.FileURL, err)
-			continue
+			return err
 		}
+		if len(batch) == 0 {
+			return nil
+		}
+		afterID = batch[len(batch)-1].ID
+		run.Scanned += len(batch)
+		invalidateClaimed(ctx, rdb, batch)
 
-		_, err = db.Exec("DELETE FROM files WHERE id = $1", file.ID)
-		if err != nil {
-			log.Printf(" Failed to delete file record (%d): %v\n", file.ID, err)
-		} else {
-			log.Printf(" Deleted expired file: %s\n", file.FileURL)
+		for _, file := range batch {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("cleanup stopped: %v", err)
+			}
+			if err := removeStorage(db, file); err != nil {
+				log.Printf(" Failed to delete storage of file %d: %v\n", file.ID, err)
+				recordDeleteFailure(db, file.ID, err)
+				run.Failed++
+				continue
+			}
+
+			err := deleteFileRecord(ctx, db, fence, file)
+			if err == lock.ErrStaleFence {
+				return err
+			}
+			if err != nil {
+				log.Printf(" Failed to delete file record (%d): %v\n", file.ID, err)
+				recordDeleteFailure(db, file.ID, err)
+				run.Failed++
+				continue
+			}
+			run.Deleted++
+			run.BytesFreed += file.Size
+			log.Printf(" Deleted expired file: %s\n", file.Filename)
+			announceDeleted(file)
 		}
+		saveRun(db, run)
 	}
+}
+
+// claimBatch marks the next batch of expired rows after afterID as 'deleting'
+// and returns them. The fencing check shares the transaction, so a holder that
+// lost its lease claims nothing.
+func claimBatch(ctx context.Context, db *sql.DB, fence int64, threshold time.Time, afterID int) ([]expiredFile, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lock.CheckFence(ctx, tx, CleanupLockName, fence); err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE files SET status = 'deleting'
+		WHERE id IN (
+			SELECT id FROM files WHERE `+expiredCondition+` AND id > $3
+			ORDER BY id LIMIT $4 FOR UPDATE SKIP LOCKED)
+		RETURNING id, filename, COALESCE(filepath, ''), COALESCE(file_url, ''), COALESCE(owner_id, ''), COALESCE(size, 0), COALESCE(charged_team, '')`,
+		threshold, cleanupMaxAttempts(), afterID, cleanupBatchSize())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming expired files: %v", err)
+	}
+	defer rows.Close()
 
+	var batch []expiredFile
+	for rows.Next() {
+		var file expiredFile
+		if err := rows.Scan(&file.ID, &file.Filename, &file.FilePath, &file.FileURL, &file.OwnerID, &file.Size, &file.Team); err != nil {
+			return nil, err
+		}
+		batch = append(batch, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	// RETURNING does not guarantee order; keyset paging needs the highest id last
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+	return batch, nil
+}
+
+// invalidateClaimed drops cached responses that still show a claimed batch;
+// 'deleting' rows are already hidden from listings, search and metadata
+func invalidateClaimed(ctx context.Context, rdb *redis.Client, batch []expiredFile) {
+	namespaces := make([]string, 0, 2*len(batch))
+	for _, file := range batch {
+		namespaces = append(namespaces, cache.FileNamespace(file.ID))
+		if file.OwnerID != "" {
+			namespaces = append(namespaces, cache.UserNamespace(file.OwnerID))
+		}
+	}
+	if err := cache.Invalidate(ctx, rdb, namespaces...); err != nil {
+		log.Println(" Cache invalidation failed after claiming expired files:", err)
+	}
+}
+
+// previewExpiredFiles counts what a real run would delete without changing anything
+func previewExpiredFiles(ctx context.Context, db *sql.DB, run *CleanupRun) error {
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(COALESCE(size, 0)), 0) FROM files WHERE `+expiredCondition,
+		time.Now().Add(-fileTTL), cleanupMaxAttempts()).Scan(&run.Scanned, &run.BytesFreed)
+	if err != nil {
+		return fmt.Errorf("error counting expired files: %v", err)
+	}
 	return nil
 }
+
+// removeStorage deletes a file's derivatives, its S3 object and its local copy.
+// Every step tolerates objects that are already gone, so it can be retried.
+func removeStorage(db *sql.DB, file expiredFile) error {
+	if err := deleteDerivatives(db, file.ID); err != nil {
+		return fmt.Errorf("derivatives: %v", err)
+	}
+	if file.FileURL != "" {
+		if err := utils.DeleteFromS3(file.FileURL); err != nil {
+			return fmt.Errorf("s3: %v", err)
+		}
+	}
+	if file.FilePath != "" {
+		if err := os.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("local: %v", err)
+		}
+	}
+	return nil
+}
+
+// recordDeleteFailure keeps the row 'deleting' for the next run and notes why
+func recordDeleteFailure(db *sql.DB, fileID int, cause error) {
+	_, err := db.Exec("UPDATE files SET delete_attempts = delete_attempts + 1, delete_error = $2 WHERE id = $1",
+		fileID, cause.Error())
+	if err != nil {
+		log.Printf(" Failed to record cleanup failure for file %d: %v\n", fileID, err)
+	}
+}
+
+// announceDeleted tells the owner and their webhooks that a file expired
+func announceDeleted(file expiredFile) {
+	if file.OwnerID == "" {
+		return
+	}
+	notify.Publish(file.OwnerID, notify.NewEvent(notify.TypeFileDeleted, notify.FileDeletedPayload{
+		FilePayload: notify.FilePayload{FileID: file.ID, Filename: file.Filename},
+		Reason:      "expired",
+	}))
+	err := webhooks.Dispatch(context.Background(), file.OwnerID, webhooks.EventFileExpired, webhooks.FileData{
+		FileID: file.ID, Filename: file.Filename, Size: file.Size, URL: file.FileURL,
+	})
+	if err != nil {
+		log.Printf(" Failed to dispatch webhooks for file %d: %v\n", file.ID, err)
+	}
+}
+
+// deleteFileRecord removes the file row and credits its size back to the owner's
+// quota and the team charged for it, in the same transaction as the fencing check
+func deleteFileRecord(ctx context.Context, db *sql.DB, fence int64, file expiredFile) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lock.CheckFence(ctx, tx, CleanupLockName, fence); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM files WHERE id = $1", file.ID); err != nil {
+		return err
+	}
+	if file.OwnerID != "" && file.Size > 0 {
+		if err := utils.ReleaseStorage(tx, file.OwnerID, file.Team, file.Size); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteDerivatives removes the thumbnails generated for a file from disk and S3.
+// Their rows go away with the file row through ON DELETE CASCADE.
+func deleteDerivatives(db *sql.DB, fileID int) error {
+	rows, err := db.Query("SELECT filepath, COALESCE(file_url, '') FROM file_derivatives WHERE file_id = $1", fileID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path, url string
+		if err := rows.Scan(&path, &url); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if url != "" {
+			if err := utils.DeleteFromS3(url); err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,78 @@
+package workers
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SOMAK939/file-sharing-platform/cache"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestClaimInvalidatesCachedFile caches a file's metadata and its owner's
+// listing, claims the file for deletion and reads both again: the reads must
+// go back to the loader, which no longer sees the 'deleting' row
+func TestClaimInvalidatesCachedFile(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	const owner = "owner@example.com"
+
+	deleting := false
+	read := func(key string, ns string) string {
+		t.Helper()
+		data, err := cache.Fetch(ctx, rdb, key, cache.Options{TTL: time.Minute, NegativeTTL: time.Minute, Namespaces: []string{ns}},
+			func(ctx context.Context) ([]byte, error) {
+				if deleting {
+					return nil, cache.ErrNotFound
+				}
+				return []byte(`[5]`), nil
+			})
+		if err == cache.ErrNotFound {
+			return "not found"
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read("file_metadata:5", cache.FileNamespace(5)); got != "[5]" {
+		t.Fatalf("metadata before claim = %s", got)
+	}
+	if got := read("user:files:"+owner, cache.UserNamespace(owner)); got != "[5]" {
+		t.Fatalf("listing before claim = %s", got)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO lock_fences")).WithArgs(CleanupLockName, int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE files SET status = 'deleting'")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "filename", "filepath", "file_url", "owner_id", "size", "charged_team"}).
+			AddRow(5, "a.txt", "", "", owner, 11, ""))
+	mock.ExpectCommit()
+	batch, err := claimBatch(ctx, db, 3, time.Now(), 0)
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("claimBatch = %v, %v", batch, err)
+	}
+	deleting = true
+	invalidateClaimed(ctx, rdb, batch)
+
+	if got := read("file_metadata:5", cache.FileNamespace(5)); got != "not found" {
+		t.Fatalf("metadata after claim = %s", got)
+	}
+	if got := read("user:files:"+owner, cache.UserNamespace(owner)); got != "not found" {
+		t.Fatalf("listing after claim = %s", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
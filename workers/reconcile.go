@@ -0,0 +1,339 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/lock"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// What RunReconcile does with orphans older than the grace period
+const (
+	ReconcileReport     = "report"     // only list problems
+	ReconcileQuarantine = "quarantine" // move orphans aside so they can be restored
+	ReconcileDelete     = "delete"     // remove orphans
+)
+
+// Where quarantined orphans go, locally and in the bucket
+const (
+	localQuarantineDir = ".quarantine"
+	s3QuarantinePrefix = "quarantine/"
+)
+
+// Storage locations compared by RunReconcile
+const (
+	LocationLocal = "local"
+	LocationS3    = "s3"
+)
+
+// ReconcileOptions controls a reconciliation pass
+type ReconcileOptions struct {
+	Action      string        // ReconcileReport, ReconcileQuarantine or ReconcileDelete
+	GracePeriod time.Duration // orphans younger than this may be uploads still in flight
+	UploadDir   string        // local storage root, "uploads" by default
+	SkipS3      bool          // compare only local storage with the database
+}
+
+// MinGracePeriod is the shortest grace period quarantine and delete accept. An
+// upload writes its local file and S3 object before committing its row, so
+// younger orphans may belong to uploads still in flight.
+const MinGracePeriod = time.Hour
+
+// DefaultGracePeriod reads RECONCILE_GRACE_PERIOD (default 24h)
+func DefaultGracePeriod() time.Duration {
+	return config.GetEnvDuration("RECONCILE_GRACE_PERIOD", 24*time.Hour)
+}
+
+// ReconcileItem is one discrepancy between storage and the database
+type ReconcileItem struct {
+	Location     string     `json:"location"`          // "local" or "s3"
+	Key          string     `json:"key"`               // file name on disk or object key
+	Kind         string     `json:"kind,omitempty"`    // "file" or "derivative" for known objects
+	FileID       int        `json:"file_id,omitempty"` // row the object belongs to
+	Size         int64      `json:"size"`              // size found in storage
+	ExpectedSize int64      `json:"expected_size,omitempty"`
+	ModTime      *time.Time `json:"mod_time,omitempty"`
+	Action       string     `json:"action,omitempty"` // what was done: "quarantined", "deleted", or "" when in grace
+	Error        string     `json:"error,omitempty"`
+}
+
+// ReconcileResult is what a reconciliation pass found and did
+type ReconcileResult struct {
+	Action         string          `json:"action"`
+	GracePeriod    string          `json:"grace_period"`
+	StartedAt      time.Time       `json:"started_at"`
+	FinishedAt     time.Time       `json:"finished_at"`
+	LocalObjects   int             `json:"local_objects"`
+	S3Objects      int             `json:"s3_objects"`
+	Records        int             `json:"records"`         // file and derivative rows checked
+	Orphans        []ReconcileItem `json:"orphans"`         // objects no row refers to
+	Missing        []ReconcileItem `json:"missing"`         // rows whose object is gone
+	SizeMismatches []ReconcileItem `json:"size_mismatches"` // object size differs from the row
+	S3Skipped      bool            `json:"s3_skipped,omitempty"`
+}
+
+// storedObject is an object found in storage
+type storedObject struct {
+	size    int64
+	modTime time.Time
+}
+
+// expectedObject is an object a row refers to
+type expectedObject struct {
+	kind   string
+	fileID int
+	size   int64 // 0 when the row does not record a size
+}
+
+// RunReconcile lists local storage, the S3 bucket, and the files and
+// file_derivatives tables, and reports objects without rows, rows without
+// objects, and size mismatches. With a quarantine or delete action, orphans
+// older than the grace period are moved aside or removed. Rows are never changed.
+//
+// Passes that change storage take the cleanup lock, so they never race a
+// cleanup pass deleting the same files, and confirm its fence before touching
+// each orphan; it returns ErrCleanupRunning if another instance holds the lock.
+func RunReconcile(ctx context.Context, db *sql.DB, rdb *redis.Client, opts ReconcileOptions) (*ReconcileResult, error) {
+	if opts.Action == "" || opts.Action == ReconcileReport {
+		return reconcile(ctx, db, opts, 0)
+	}
+	if opts.GracePeriod < MinGracePeriod {
+		return nil, fmt.Errorf("grace period must be at least %s to %s orphans", MinGracePeriod, opts.Action)
+	}
+	var report *ReconcileResult
+	ran, err := lock.Run(ctx, rdb, CleanupLockName, cleanupLockTTL(), func(ctx context.Context, fence int64) error {
+		var err error
+		report, err = reconcile(ctx, db, opts, fence)
+		return err
+	})
+	if err == nil && !ran {
+		return nil, ErrCleanupRunning
+	}
+	return report, err
+}
+
+// reconcile does the work of RunReconcile. fence is the cleanup lock's token
+// for passes that change storage.
+func reconcile(ctx context.Context, db *sql.DB, opts ReconcileOptions, fence int64) (*ReconcileResult, error) {
+	if opts.Action == "" {
+		opts.Action = ReconcileReport
+	}
+	if opts.Action != ReconcileReport && opts.Action != ReconcileQuarantine && opts.Action != ReconcileDelete {
+		return nil, fmt.Errorf("unknown reconcile action %q", opts.Action)
+	}
+	if opts.UploadDir == "" {
+		opts.UploadDir = "uploads"
+	}
+
+	report := &ReconcileResult{
+		Action:         opts.Action,
+		GracePeriod:    opts.GracePeriod.String(),
+		StartedAt:      time.Now().UTC(),
+		Orphans:        []ReconcileItem{},
+		Missing:        []ReconcileItem{},
+		SizeMismatches: []ReconcileItem{},
+		S3Skipped:      opts.SkipS3,
+	}
+
+	// Load the rows first: an object uploaded after this point is younger than
+	// any sensible grace period, so it is reported but never touched
+	localExpected, s3Expected, records, err := loadExpectedObjects(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	report.Records = records
+
+	local, err := listLocalObjects(opts.UploadDir)
+	if err != nil {
+		return nil, err
+	}
+	report.LocalObjects = len(local)
+	compare(report, LocationLocal, local, localExpected)
+
+	if !opts.SkipS3 {
+		s3Objects, err := listS3Objects(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.S3Objects = len(s3Objects)
+		compare(report, LocationS3, s3Objects, s3Expected)
+	}
+
+	if opts.Action != ReconcileReport {
+		cutoff := time.Now().Add(-opts.GracePeriod)
+		for i := range report.Orphans {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			orphan := &report.Orphans[i]
+			if orphan.ModTime.After(cutoff) { // orphans always have a mod time
+				continue
+			}
+			// A holder whose lease lapsed must stop before a new holder's
+			// cleanup or reconcile pass sees the same files
+			if err := confirmFence(ctx, db, fence); err != nil {
+				return report, err
+			}
+			if err := resolveOrphan(ctx, opts, orphan); err != nil {
+				orphan.Error = err.Error()
+				log.Printf(" Failed to %s orphan %s %s: %v\n", opts.Action, orphan.Location, orphan.Key, err)
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	log.Printf(" Reconcile (%s): %d orphans, %d missing, %d size mismatches\n",
+		opts.Action, len(report.Orphans), len(report.Missing), len(report.SizeMismatches))
+	return report, nil
+}
+
+// loadExpectedObjects returns the local file names and S3 keys the database
+// refers to. Files being deleted by cleanup are skipped.
+func loadExpectedObjects(ctx context.Context, db *sql.DB) (map[string]expectedObject, map[string]expectedObject, int, error) {
+	local := map[string]expectedObject{}
+	remote := map[string]expectedObject{}
+	records := 0
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT 'file', id, COALESCE(filepath, ''), COALESCE(file_url, ''), COALESCE(size, 0)
+		FROM files WHERE status <> 'deleting'
+		UNION ALL
+		SELECT 'derivative', d.file_id, d.filepath, COALESCE(d.file_url, ''), COALESCE(d.bytes, 0)
+		FROM file_derivatives d JOIN files f ON f.id = d.file_id WHERE f.status <> 'deleting'`)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error loading file records: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, path, url string
+		var obj expectedObject
+		if err := rows.Scan(&kind, &obj.fileID, &path, &url, &obj.size); err != nil {
+			return nil, nil, 0, err
+		}
+		obj.kind = kind
+		records++
+		if path != "" {
+			local[filepath.Base(path)] = obj
+		}
+		if url != "" {
+			remote[utils.S3KeyFromURL(url)] = obj
+		}
+	}
+	return local, remote, records, rows.Err()
+}
+
+// listLocalObjects lists the regular files directly under dir
+func listLocalObjects(dir string) (map[string]storedObject, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", dir, err)
+	}
+	objects := make(map[string]storedObject, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue // skips the quarantine directory too
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed while listing
+		}
+		objects[entry.Name()] = storedObject{size: info.Size(), modTime: info.ModTime()}
+	}
+	return objects, nil
+}
+
+// listS3Objects lists the bucket outside the quarantine prefix
+func listS3Objects(ctx context.Context) (map[string]storedObject, error) {
+	listed, err := utils.ListS3Objects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string]storedObject, len(listed))
+	for _, obj := range listed {
+		if strings.HasPrefix(obj.Key, s3QuarantinePrefix) {
+			continue
+		}
+		objects[obj.Key] = storedObject{size: obj.Size, modTime: obj.LastModified}
+	}
+	return objects, nil
+}
+
+// compare adds the discrepancies between one storage location and the rows to report
+func compare(report *ReconcileResult, location string, found map[string]storedObject, expected map[string]expectedObject) {
+	for key, obj := range found {
+		want, ok := expected[key]
+		if !ok {
+			report.Orphans = append(report.Orphans, ReconcileItem{
+				Location: location, Key: key, Size: obj.size, ModTime: &obj.modTime,
+			})
+			continue
+		}
+		if want.size > 0 && want.size != obj.size {
+			report.SizeMismatches = append(report.SizeMismatches, ReconcileItem{
+				Location: location, Key: key, Kind: want.kind, FileID: want.fileID,
+				Size: obj.size, ExpectedSize: want.size, ModTime: &obj.modTime,
+			})
+		}
+	}
+	for key, want := range expected {
+		if _, ok := found[key]; !ok {
+			report.Missing = append(report.Missing, ReconcileItem{
+				Location: location, Key: key, Kind: want.kind, FileID: want.fileID, ExpectedSize: want.size,
+			})
+		}
+	}
+}
+
+// confirmFence checks that fence is still the newest cleanup lock token
+func confirmFence(ctx context.Context, db *sql.DB, fence int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := lock.CheckFence(ctx, tx, CleanupLockName, fence); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resolveOrphan quarantines or deletes one orphan and records what it did
+func resolveOrphan(ctx context.Context, opts ReconcileOptions, orphan *ReconcileItem) error {
+	switch {
+	case orphan.Location == LocationLocal && opts.Action == ReconcileQuarantine:
+		dir := filepath.Join(opts.UploadDir, localQuarantineDir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(opts.UploadDir, orphan.Key), filepath.Join(dir, orphan.Key)); err != nil {
+			return err
+		}
+		orphan.Action = "quarantined"
+	case orphan.Location == LocationLocal:
+		if err := os.Remove(filepath.Join(opts.UploadDir, orphan.Key)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		orphan.Action = "deleted"
+	case opts.Action == ReconcileQuarantine:
+		if err := utils.MoveS3Key(ctx, orphan.Key, s3QuarantinePrefix+orphan.Key); err != nil {
+			return err
+		}
+		orphan.Action = "quarantined"
+	default:
+		if err := utils.DeleteS3Key(ctx, orphan.Key); err != nil {
+			return err
+		}
+		orphan.Action = "deleted"
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package workers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SOMAK939/file-sharing-platform/lock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// reconcileEnv has an upload directory holding one orphan older than any grace period
+func reconcileEnv(t *testing.T) (sqlmock.Sqlmock, func(ReconcileOptions) (*ReconcileResult, error), string) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dir := t.TempDir()
+	orphan := filepath.Join(dir, "1600000000_orphan.txt")
+	if err := os.WriteFile(orphan, []byte("left behind"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+	run := func(opts ReconcileOptions) (*ReconcileResult, error) {
+		opts.UploadDir, opts.SkipS3 = dir, true
+		return RunReconcile(context.Background(), db, rdb, opts)
+	}
+	return mock, run, orphan
+}
+
+func expectNoRows(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 'file', id")).
+		WillReturnRows(sqlmock.NewRows([]string{"kind", "id", "filepath", "file_url", "size"}))
+}
+
+func TestReconcileDeletesOrphanUnderFence(t *testing.T) {
+	mock, run, orphan := reconcileEnv(t)
+	expectNoRows(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO lock_fences")).WithArgs(CleanupLockName, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	report, err := run(ReconcileOptions{Action: ReconcileDelete, GracePeriod: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Action != "deleted" {
+		t.Fatalf("orphans = %+v", report.Orphans)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("orphan still there: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReconcileStopsOnStaleFence checks that a pass whose lease was taken over
+// leaves the orphan alone
+func TestReconcileStopsOnStaleFence(t *testing.T) {
+	mock, run, orphan := reconcileEnv(t)
+	expectNoRows(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO lock_fences")).WithArgs(CleanupLockName, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0)) // a newer fence is recorded
+	mock.ExpectRollback()
+
+	report, err := run(ReconcileOptions{Action: ReconcileDelete, GracePeriod: 24 * time.Hour})
+	if err != lock.ErrStaleFence {
+		t.Fatalf("RunReconcile = %v, want lock.ErrStaleFence", err)
+	}
+	if report == nil || report.Orphans[0].Action != "" {
+		t.Fatalf("report = %+v", report)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("orphan removed after the fence went stale: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileRequiresMinimumGrace(t *testing.T) {
+	mock, run, orphan := reconcileEnv(t)
+	for _, action := range []string{ReconcileQuarantine, ReconcileDelete} {
+		if _, err := run(ReconcileOptions{Action: action, GracePeriod: 0}); err == nil {
+			t.Errorf("%s with no grace period accepted", action)
+		}
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("orphan touched: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/queue"
+)
+
+// scanRetryAfter is how long a file may sit in 'pending_scan' after its job was
+// queued before it gets another one. It should stay well under fileTTL so a
+// file whose job was lost is scanned before it expires.
+func scanRetryAfter() time.Duration {
+	return config.GetEnvDuration("SCAN_RETRY_AFTER", 10*time.Minute)
+}
+
+// staleScan is a 'pending_scan' file that gets a new processing job
+type staleScan struct {
+	ID       int
+	Filename string
+	OwnerID  string
+}
+
+// StartScanSweeper periodically re-queues processing for files still waiting
+// for a scan: the job may never have been queued, or have given up while the
+// scanner was down. Rows are claimed by a single UPDATE, so every replica can
+// sweep without a lock.
+func StartScanSweeper(db *sql.DB, jobQueue *queue.Queue) {
+	fmt.Println(" Starting Background Scan Sweeper...")
+	ticker := time.NewTicker(scanRetryAfter() / 2)
+	go func() {
+		for range ticker.C {
+			n, err := SweepStaleScans(context.Background(), db, jobQueue)
+			if err != nil {
+				log.Println(" Scan sweep failed:", err)
+			}
+			if n > 0 {
+				log.Printf(" Scan sweep re-queued %d files\n", n)
+			}
+		}
+	}()
+}
+
+// SweepStaleScans queues a processing job for each file that has waited
+// scanRetryAfter for a scan and returns how many were queued
+func SweepStaleScans(ctx context.Context, db *sql.DB, jobQueue *queue.Queue) (int, error) {
+	files, err := claimStaleScans(ctx, db, time.Now().Add(-scanRetryAfter()))
+	if err != nil {
+		return 0, err
+	}
+	queued := 0
+	for _, file := range files {
+		// A failure here is picked up again by a later sweep
+		_, err := jobQueue.Enqueue(ctx, queue.TypeProcessUpload,
+			queue.ProcessUploadPayload{FileID: file.ID, Filename: file.Filename},
+			queue.Meta{Owner: file.OwnerID, FileID: file.ID})
+		if err != nil {
+			log.Printf(" Failed to re-queue scan for file %d: %v\n", file.ID, err)
+			continue
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// claimStaleScans stamps scan_queued_at on 'pending_scan' files last queued
+// before threshold and returns them
+func claimStaleScans(ctx context.Context, db *sql.DB, threshold time.Time) ([]staleScan, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE files SET scan_queued_at = NOW()
+		WHERE id IN (
+			SELECT id FROM files
+			WHERE status = 'pending_scan' AND COALESCE(scan_queued_at, uploaded_at) < $1
+			ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED)
+		RETURNING id, filename, COALESCE(owner_id, '')`,
+		threshold, cleanupBatchSize())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming stale scans: %v", err)
+	}
+	defer rows.Close()
+
+	var files []staleScan
+	for rows.Next() {
+		var file staleScan
+		if err := rows.Scan(&file.ID, &file.Filename, &file.OwnerID); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,47 @@
+package workers
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/SOMAK939/file-sharing-platform/queue"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestSweepRequeuesStaleScans claims a file left in 'pending_scan' and checks
+// that a processing job is queued for it
+func TestSweepRequeuesStaleScans(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	jobQueue := queue.New(rdb, queue.Options{})
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE files SET scan_queued_at = NOW()")).
+		WithArgs(sqlmock.AnyArg(), cleanupBatchSize()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "filename", "owner_id"}).
+			AddRow(12, "report.pdf", "owner@example.com"))
+	n, err := SweepStaleScans(ctx, db, jobQueue)
+	if err != nil || n != 1 {
+		t.Fatalf("SweepStaleScans = %d, %v; want 1 job", n, err)
+	}
+
+	statuses, err := jobQueue.FileStatuses(ctx, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Type != queue.TypeProcessUpload || statuses[0].State != queue.StateQueued {
+		t.Fatalf("jobs for file 12 = %+v, want one queued %s job", statuses, queue.TypeProcessUpload)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
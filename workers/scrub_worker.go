@@ -0,0 +1,280 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SOMAK939/file-sharing-platform/config"
+	"github.com/SOMAK939/file-sharing-platform/lock"
+	"github.com/SOMAK939/file-sharing-platform/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// ScrubLockName is the distributed lock that elects the replica running a scrub pass
+const ScrubLockName = "scrub-worker"
+
+// Integrity states recorded on files rows
+const (
+	IntegrityUnverified = "unverified"
+	IntegrityOK         = "ok"
+	IntegrityCorrupt    = "corrupt" // a copy's size or checksum does not match the row
+	IntegrityMissing    = "missing" // no copy could be read
+)
+
+// integrityUnknown is returned by verifyFile when no copy could be read but
+// none is known to be gone, e.g. the local copy was cleaned up and S3 is
+// unreachable. It is not stored: the row keeps its previous status.
+const integrityUnknown = "unknown"
+
+// ErrScrubRunning is returned by RunScrub when another instance holds the scrub lock
+var ErrScrubRunning = errors.New("scrub is already running on another instance")
+
+// ScrubResult counts what one scrub pass verified
+type ScrubResult struct {
+	Checked int `json:"checked"`
+	OK      int `json:"ok"`
+	Corrupt int `json:"corrupt"`
+	Missing int `json:"missing"`
+	Skipped int `json:"skipped"` // could not be verified, e.g. S3 unreachable
+}
+
+// scrubFile is a row to verify
+type scrubFile struct {
+	ID       int
+	Filename string
+	FilePath string
+	FileURL  string
+	Size     int64
+	SHA256   string
+}
+
+// StartScrubWorker re-reads stored files on a schedule (SCRUB_INTERVAL, default
+// 6h), least recently verified first, and flags copies whose size or SHA-256
+// no longer matches the files row
+func StartScrubWorker(db *sql.DB, rdb *redis.Client) {
+	fmt.Println(" Starting Background Scrub Worker...")
+	ticker := time.NewTicker(config.GetEnvDuration("SCRUB_INTERVAL", 6*time.Hour))
+	go func() {
+		for range ticker.C {
+			result, err := RunScrub(context.Background(), db, rdb)
+			if err == ErrScrubRunning {
+				continue
+			}
+			if err != nil {
+				log.Println(" Scrub job failed:", err)
+			}
+			if result != nil {
+				log.Printf(" Scrub: checked %d, ok %d, corrupt %d, missing %d, skipped %d\n",
+					result.Checked, result.OK, result.Corrupt, result.Missing, result.Skipped)
+			}
+		}
+	}()
+}
+
+// RunScrub verifies one batch of SCRUB_BATCH_SIZE files (default 100) under the scrub lock
+func RunScrub(ctx context.Context, db *sql.DB, rdb *redis.Client) (*ScrubResult, error) {
+	result := &ScrubResult{}
+	ran, err := lock.Run(ctx, rdb, ScrubLockName, cleanupLockTTL(), func(ctx context.Context, fence int64) error {
+		return scrubBatch(ctx, db, result)
+	})
+	if err == nil && !ran {
+		return nil, ErrScrubRunning
+	}
+	return result, err
+}
+
+func scrubBatch(ctx context.Context, db *sql.DB, result *ScrubResult) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, filename, COALESCE(filepath, ''), COALESCE(file_url, ''), COALESCE(size, 0), COALESCE(sha256, '')
+		FROM files WHERE status = 'active'
+		ORDER BY verified_at NULLS FIRST, id LIMIT $1`,
+		config.GetEnvInt64("SCRUB_BATCH_SIZE", 100))
+	if err != nil {
+		return fmt.Errorf("error fetching files to scrub: %v", err)
+	}
+	var batch []scrubFile
+	for rows.Next() {
+		var f scrubFile
+		if err := rows.Scan(&f.ID, &f.Filename, &f.FilePath, &f.FileURL, &f.Size, &f.SHA256); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, f := range batch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		status, baseline, detail := verifyFile(ctx, f)
+		result.Checked++
+		switch status {
+		case IntegrityOK:
+			result.OK++
+		case IntegrityCorrupt:
+			result.Corrupt++
+			log.Printf(" Integrity check failed for file %d (%s): %s\n", f.ID, f.Filename, detail)
+		case IntegrityMissing:
+			result.Missing++
+			log.Printf(" No readable copy of file %d (%s): %s\n", f.ID, f.Filename, detail)
+		case integrityUnknown:
+			// Note why, but keep the previous status and leave verified_at alone
+			// so the file is checked again on the next pass
+			result.Skipped++
+			log.Printf(" Could not verify file %d (%s): %s\n", f.ID, f.Filename, detail)
+			_, err := db.ExecContext(ctx, "UPDATE files SET integrity_error = $2 WHERE id = $1",
+				f.ID, "could not verify: "+detail)
+			if err != nil {
+				return fmt.Errorf("error recording scrub of file %d: %v", f.ID, err)
+			}
+			continue
+		}
+
+		var errMsg *string
+		if detail != "" {
+			errMsg = &detail
+		}
+		_, err := db.ExecContext(ctx, `UPDATE files
+			SET integrity_status = $2, integrity_error = $3, verified_at = NOW(), sha256 = COALESCE(sha256, NULLIF($4, ''))
+			WHERE id = $1`, f.ID, status, errMsg, baseline)
+		if err != nil {
+			return fmt.Errorf("error recording scrub of file %d: %v", f.ID, err)
+		}
+	}
+	return nil
+}
+
+// verifyFile hashes every readable copy of f and compares it with the row. Files
+// uploaded before checksums were recorded get the hash of their copies as a
+// baseline, provided the copies agree and match the recorded size. A file is
+// only missing when every copy is known to be gone; if one could not be read it
+// is integrityUnknown.
+func verifyFile(ctx context.Context, f scrubFile) (status, baseline, detail string) {
+	type copySum struct {
+		location string
+		sum      string
+		size     int64
+	}
+	var sums []copySum
+	var problems []string // mismatches; these make the file corrupt
+	var notes []string    // copies that could not be read, e.g. S3 unreachable
+	unreadable := false   // a copy may exist but could not be read
+
+	if f.FilePath != "" {
+		if file, err := os.Open(f.FilePath); err == nil {
+			sum, n, err := utils.SHA256(file)
+			file.Close()
+			if err != nil {
+				notes = append(notes, fmt.Sprintf("local read failed: %v", err))
+				unreadable = true
+			} else {
+				sums = append(sums, copySum{LocationLocal, sum, n})
+			}
+		} else if !os.IsNotExist(err) {
+			notes = append(notes, fmt.Sprintf("local open failed: %v", err))
+			unreadable = true
+		}
+	}
+	if f.FileURL != "" && !config.GetEnvBool("SCRUB_S3", true) {
+		unreadable = true // the S3 object is not checked, so it may still be there
+	} else if f.FileURL != "" {
+		obj, err := utils.OpenS3Object(ctx, f.FileURL)
+		if err == nil {
+			sum, n, err := utils.SHA256(obj)
+			obj.Close()
+			if err != nil {
+				notes = append(notes, fmt.Sprintf("s3 read failed: %v", err))
+				unreadable = true
+			} else {
+				sums = append(sums, copySum{LocationS3, sum, n})
+			}
+		} else {
+			notes = append(notes, err.Error())
+			unreadable = unreadable || !errors.Is(err, utils.ErrS3ObjectMissing)
+		}
+	}
+
+	if len(sums) == 0 {
+		if unreadable {
+			if len(notes) == 0 {
+				notes = append(notes, "S3 object not checked (SCRUB_S3=false) and no local copy")
+			}
+			return integrityUnknown, "", strings.Join(notes, "; ")
+		}
+		if len(notes) == 0 {
+			notes = append(notes, "no local copy or S3 object")
+		}
+		return IntegrityMissing, "", strings.Join(notes, "; ")
+	}
+
+	expected := f.SHA256
+	for _, c := range sums {
+		if f.Size > 0 && c.size != f.Size { // older rows may not record a size
+			problems = append(problems, fmt.Sprintf("%s copy is %d bytes, expected %d", c.location, c.size, f.Size))
+			continue
+		}
+		if expected == "" {
+			expected = c.sum // baseline from the first intact copy
+			baseline = c.sum
+		}
+		if c.sum != expected {
+			problems = append(problems, fmt.Sprintf("%s copy sha256 %s does not match %s", c.location, c.sum, expected))
+			baseline = "" // copies disagree, so neither can be trusted as a baseline
+		}
+	}
+	if len(problems) > 0 {
+		return IntegrityCorrupt, baseline, strings.Join(append(problems, notes...), "; ")
+	}
+	return IntegrityOK, baseline, strings.Join(notes, "; ")
+}
+
+// IntegrityIssue is a file the scrub worker flagged
+type IntegrityIssue struct {
+	FileID     int        `json:"file_id"`
+	Filename   string     `json:"filename"`
+	Owner      string     `json:"owner"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error"`
+	SHA256     string     `json:"sha256,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// ListIntegrityIssues returns flagged files, most recently verified first. An
+// empty status lists both corrupt and missing files.
+func ListIntegrityIssues(ctx context.Context, db *sql.DB, status string, limit int) ([]IntegrityIssue, error) {
+	statuses := []string{IntegrityCorrupt, IntegrityMissing}
+	if status != "" {
+		statuses = []string{status}
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, filename, COALESCE(owner_id, ''), integrity_status, COALESCE(integrity_error, ''),
+		       COALESCE(sha256, ''), verified_at
+		FROM files WHERE integrity_status = ANY($1)
+		ORDER BY verified_at DESC NULLS LAST LIMIT $2`, statuses, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issues := []IntegrityIssue{}
+	for rows.Next() {
+		var issue IntegrityIssue
+		err := rows.Scan(&issue.FileID, &issue.Filename, &issue.Owner, &issue.Status, &issue.Error,
+			&issue.SHA256, &issue.VerifiedAt)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, rows.Err()
+}